@@ -35,10 +35,6 @@ const (
 	DefaultManateeLibPath = "/usr/local/lib/libmanatee.so"
 )
 
-var (
-	v2_208 = initV2_208()
-)
-
 func getCommitInfo(workingDir string) (string, error) {
 	cmd := exec.Command("git", "rev-parse", "--short", "HEAD")
 	cmd.Dir = workingDir
@@ -70,15 +66,7 @@ func getCurrentDatetime(loc *time.Location) string {
 	return time.Now().In(loc).Format(time.RFC3339)
 }
 
-func initV2_208() Version {
-	v2_208, err := ParseManateeVersion("2.208")
-	if err != nil {
-		panic(err)
-	}
-	return v2_208
-}
-
-func initManateeSources(version Version, manateeSrc string, withPcre2 bool) error {
+func initManateeSources(version Version, recipe BuildRecipe, manateeSrc string, withPcre2 bool) error {
 	isFile, err := fs.IsFile(path.Join(manateeSrc, "config.hh"))
 	if err != nil {
 		return fmt.Errorf("failed to test for config.hh: %w", err)
@@ -99,37 +87,36 @@ func initManateeSources(version Version, manateeSrc string, withPcre2 bool) erro
 	if withPcre2 {
 		pcreParam = "--with-pcre2"
 	}
-	cmd := exec.Command("./configure", pcreParam, "--disable-python", "--disable-pthread")
+	configureArgs := []string{pcreParam}
+	configureArgs = append(configureArgs, recipe.ConfigureFlags...)
+	configureArgs = append(configureArgs, version.ManateeVariant().ConfigureFlags...)
+	cmd := exec.Command("./configure", configureArgs...)
 	cmd.Env = env.Export()
 	cmd.Dir = manateeSrc
 	err = cmd.Run()
 	if err != nil {
 		return err
 	}
-	if version.Ge(v2_208) {
+	for _, auxLib := range recipe.AuxLibs {
 		cmd := exec.Command("make")
-		cmd.Dir = path.Join(manateeSrc, "hat-trie")
-		err := cmd.Run()
-		if err != nil {
-			return err
+		cmd.Dir = path.Join(manateeSrc, auxLib)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to build %s: %w", auxLib, err)
 		}
-		cmd = exec.Command("make")
-		cmd.Dir = path.Join(manateeSrc, "fsa3")
-		err = cmd.Run()
-		return err
 	}
 	return nil
 }
 
 func buildProject(
 	ctx *OperationSequence,
-	version Version,
+	recipe BuildRecipe,
 	workingDir,
 	manateeSrc,
 	manateeLib string,
 	test bool,
-	binaryName string,
-	cmdDir string,
+	stripSymbols bool,
+	outputDir string,
+	targets []BuildTarget,
 	prepareOnly bool,
 ) error {
 
@@ -144,28 +131,16 @@ func buildProject(
 
 	dt := getCurrentDatetime(ctx.TimeLocation())
 	ldFlags := fmt.Sprintf(
-		`-w -s -X main.version='%s' -X main.buildDate='%s' -X main.gitCommit='%s'`,
+		`-X main.version='%s' -X main.buildDate='%s' -X main.gitCommit='%s'`,
 		ver, dt, commit,
 	)
-	subdirs := []string{fmt.Sprintf("-I%s", manateeSrc)}
-	buildEnv := make(EnvironmentVars)
-	if version.Ge(v2_208) {
-		for _, dir := range []string{"finlib", "fsa3", "hat-trie"} {
-			subdirs = append(subdirs, "-I"+path.Join(manateeSrc, dir))
-		}
-		buildEnv["CGO_CXXFLAGS"] = fmt.Sprintf(
-			`-std=c++14 -I%s/corp -I%s/concord -I%s/query`, manateeSrc, manateeSrc, manateeSrc)
-		buildEnv["CGO_CPPFLAGS"] = strings.Join(subdirs, " ")
-		buildEnv["CGO_LDFLAGS"] = fmt.Sprintf(
-			`-lmanatee -L%s -lhat-trie -L%s -lfsa3 -L%s`,
-			manateeLib, manateeLib, path.Join(manateeSrc, "fsa3/.libs"))
-
-	} else {
-		buildEnv["CGO_CXXFLAGS"] = fmt.Sprintf(
-			`-std=c++14 -I%s/corp -I%s/concord -I%s/query`, manateeSrc, manateeSrc, manateeSrc)
-		buildEnv["CGO_CPPFLAGS"] = strings.Join(subdirs, " ")
-		buildEnv["CGO_LDFLAGS"] = fmt.Sprintf(`-lmanatee -L%s`, manateeLib)
+	if stripSymbols {
+		ldFlags = "-w -s " + ldFlags
 	}
+	buildEnv := make(EnvironmentVars)
+	buildEnv["CGO_CXXFLAGS"] = recipe.CXXFlags(manateeSrc)
+	buildEnv["CGO_CPPFLAGS"] = recipe.CPPFlags(manateeSrc)
+	buildEnv["CGO_LDFLAGS"] = recipe.LDFlags(manateeSrc, manateeLib)
 
 	if prepareOnly {
 		for k, v := range buildEnv {
@@ -183,11 +158,6 @@ func buildProject(
 	currEnv := GetEnvironmentVars()
 	currEnv.UpdateBy(buildEnv)
 
-	var cmdDirStr string
-	if cmdDir != "" {
-		cmdDirStr = "./" + filepath.Join(workingDir, "cmd", cmdDir)
-	}
-
 	var cmd *exec.Cmd
 
 	fmt.Fprintln(os.Stderr, "\nRunning GENERATE:")
@@ -211,11 +181,33 @@ func buildProject(
 		}
 	}
 
-	fmt.Fprintln(os.Stderr, "\nRunning BUILD:")
-	cmd = exec.Command(
-		"bash",
-		"-c",
-		fmt.Sprintf(`go build -o %s -ldflags "%s" %s`, binaryName, ldFlags, cmdDirStr),
-	)
-	return RunCommand(cmd, WithDir(workingDir), WithEnv(currEnv), WithPrintIfErr())
+	if outputDir != "" {
+		if err := os.MkdirAll(filepath.Join(workingDir, outputDir), 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+	for _, target := range targets {
+		var cmdDirStr string
+		if target.CmdDir != "" {
+			cmdDirStr = "./" + filepath.Join("cmd", target.CmdDir)
+		}
+		targetLdFlags := ldFlags
+		if target.LdFlags != "" {
+			targetLdFlags += " " + target.LdFlags
+		}
+		fmt.Fprintf(os.Stderr, "\nRunning BUILD of %s:\n", target.BinaryName)
+		cmd = exec.Command(
+			"bash",
+			"-c",
+			fmt.Sprintf(
+				`go build -o %s -ldflags "%s" %s`,
+				filepath.Join(outputDir, target.BinaryName), targetLdFlags, cmdDirStr,
+			),
+		)
+		err = RunCommand(cmd, WithDir(workingDir), WithEnv(currEnv), WithPrintIfErr())
+		if err != nil {
+			return fmt.Errorf("failed to build %s: %w", target.BinaryName, err)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,414 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	cacheDirName = "manabuild"
+
+	cacheKindSources = "sources"
+	cacheKindArchive = "archive"
+	cacheKindPartial = "partial"
+)
+
+// defaultCacheDir returns a default cache root
+// (typically $XDG_CACHE_HOME/manabuild)
+func defaultCacheDir() (string, error) {
+	userCache, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine cache directory (please set cacheDir): %w", err)
+	}
+	return filepath.Join(userCache, cacheDirName), nil
+}
+
+// CacheRoot returns a directory where Manatee source archives
+// are downloaded and unpacked.
+func (conf *Conf) CacheRoot() (string, error) {
+	if conf.CacheDir != "" {
+		return conf.CacheDir, nil
+	}
+	return defaultCacheDir()
+}
+
+// versionFromCachedName extracts a version from names like
+// manatee-open-2.225.8, manatee-open-2.225.8-cnc.tar.gz or
+// manatee-open-2.225.8.tar.gz.0a1b2c3d.part (see downloadPartPath)
+func versionFromCachedName(name string) (Version, bool) {
+	if !strings.HasPrefix(name, "manatee-open-") {
+		return Version{}, false
+	}
+	name = strings.TrimPrefix(name, "manatee-open-")
+	if i := strings.Index(name, ".tar.gz"); i >= 0 {
+		name = name[:i]
+	}
+	v, err := ParseManateeVersion(name)
+	return v, err == nil
+}
+
+// touchCacheEntry marks a cached item as recently used
+func touchCacheEntry(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+// cacheEntry is an unpacked source tree, a source archive
+// or an unfinished download of an archive stored in the cache
+type cacheEntry struct {
+	Name     string    `json:"name"`
+	Kind     string    `json:"kind"`
+	Version  Version   `json:"-"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+func (ce cacheEntry) MarshalJSON() ([]byte, error) {
+	type entry cacheEntry
+	return json.Marshal(struct {
+		entry
+		Version string `json:"version"`
+	}{entry(ce), ce.Version.Full()})
+}
+
+func dirSize(dir string) (int64, error) {
+	var ans int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			ans += info.Size()
+		}
+		return nil
+	})
+	return ans, err
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// listCacheEntries returns all the Manatee source trees, archives
+// and unfinished archive downloads found in the cache root. Other
+// files are ignored.
+func listCacheEntries(cacheDir string) ([]cacheEntry, error) {
+	ans := make([]cacheEntry, 0, 10)
+	items, err := os.ReadDir(cacheDir)
+	if os.IsNotExist(err) {
+		return ans, nil
+
+	} else if err != nil {
+		return ans, fmt.Errorf("failed to list cache %s: %w", cacheDir, err)
+	}
+	for _, item := range items {
+		v, ok := versionFromCachedName(item.Name())
+		if !ok {
+			continue
+		}
+		info, err := item.Info()
+		if err != nil {
+			return ans, fmt.Errorf("failed to list cache %s: %w", cacheDir, err)
+		}
+		entry := cacheEntry{
+			Name:     item.Name(),
+			Version:  v,
+			Path:     filepath.Join(cacheDir, item.Name()),
+			LastUsed: info.ModTime(),
+		}
+		if item.IsDir() {
+			entry.Kind = cacheKindSources
+			entry.Size, err = dirSize(entry.Path)
+			if err != nil {
+				return ans, fmt.Errorf("failed to determine size of %s: %w", entry.Path, err)
+			}
+
+		} else if strings.HasSuffix(item.Name(), ".tar.gz") {
+			entry.Kind = cacheKindArchive
+			entry.Size = info.Size()
+
+		} else if strings.HasSuffix(item.Name(), downloadPartSuffix) {
+			entry.Kind = cacheKindPartial
+			entry.Size = info.Size()
+
+		} else {
+			continue
+		}
+		ans = append(ans, entry)
+	}
+	return ans, nil
+}
+
+// removeCacheEntries removes the provided entries and returns
+// the ones actually removed. Entries locked by a running build
+// (see lockManateeSrc) are skipped.
+func removeCacheEntries(cacheDir string, entries []cacheEntry) ([]cacheEntry, []cacheEntry, error) {
+	removed := make([]cacheEntry, 0, len(entries))
+	skipped := make([]cacheEntry, 0, len(entries))
+	for _, entry := range entries {
+		lock, err := tryFileLock(manateeSrcLockPath(cacheDir, entry.Version, ""))
+		if err != nil {
+			return removed, skipped, err
+		}
+		if lock == nil {
+			skipped = append(skipped, entry)
+			continue
+		}
+		err = os.RemoveAll(entry.Path)
+		lock.Release()
+		if err != nil {
+			return removed, skipped, fmt.Errorf("failed to remove %s: %w", entry.Path, err)
+		}
+		removed = append(removed, entry)
+	}
+	return removed, skipped, nil
+}
+
+// cleanCache removes cached items of the specified versions
+// or all the cached items in case no versions are specified.
+func cleanCache(cacheDir string, versions []Version) ([]cacheEntry, []cacheEntry, error) {
+	entries, err := listCacheEntries(cacheDir)
+	if err != nil {
+		return []cacheEntry{}, []cacheEntry{}, err
+	}
+	toRemove := make([]cacheEntry, 0, len(entries))
+	for _, entry := range entries {
+		if len(versions) == 0 {
+			toRemove = append(toRemove, entry)
+			continue
+		}
+		for _, v := range versions {
+			if entry.Version.Eq(v) {
+				toRemove = append(toRemove, entry)
+				break
+			}
+		}
+	}
+	return removeCacheEntries(cacheDir, toRemove)
+}
+
+// pruneCache keeps cached items of `keep` most recently used versions
+// and removes the rest. A version is considered used when any of its
+// items (sources, archive) is used.
+func pruneCache(cacheDir string, keep int) ([]cacheEntry, []cacheEntry, error) {
+	if keep < 0 {
+		return []cacheEntry{}, []cacheEntry{}, fmt.Errorf("invalid number of versions to keep: %d", keep)
+	}
+	entries, err := listCacheEntries(cacheDir)
+	if err != nil {
+		return []cacheEntry{}, []cacheEntry{}, err
+	}
+	lastUsed := make(map[string]time.Time)
+	for _, entry := range entries {
+		if entry.LastUsed.After(lastUsed[entry.Version.Full()]) {
+			lastUsed[entry.Version.Full()] = entry.LastUsed
+		}
+	}
+	versions := make([]string, 0, len(lastUsed))
+	for v := range lastUsed {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return lastUsed[versions[i]].After(lastUsed[versions[j]])
+	})
+	if len(versions) <= keep {
+		return []cacheEntry{}, []cacheEntry{}, nil
+	}
+	expired := make(map[string]bool)
+	for _, v := range versions[keep:] {
+		expired[v] = true
+	}
+	toRemove := make([]cacheEntry, 0, len(entries))
+	for _, entry := range entries {
+		if expired[entry.Version.Full()] {
+			toRemove = append(toRemove, entry)
+		}
+	}
+	return removeCacheEntries(cacheDir, toRemove)
+}
+
+// cachedArchiveChecksums calculates SHA-256 checksums of cached
+// source archives of the specified versions (or of all the cached
+// archives in case no versions are specified). The result can be
+// used as a checksums.json file or as the `checksums` config value.
+//
+// Note that the archives are hashed as they are (trust on first use),
+// i.e. the checksums are only as trustworthy as the mirror the archives
+// were downloaded from. Before shipping them, the archives must be
+// verified against a trusted source (e.g. the upstream's signatures).
+func cachedArchiveChecksums(cacheDir string, versions []Version) (checksumsFile, error) {
+	ans := checksumsFile{Checksums: make(map[string]string)}
+	entries, err := listCacheEntries(cacheDir)
+	if err != nil {
+		return ans, err
+	}
+	for _, entry := range entries {
+		if entry.Kind != cacheKindArchive {
+			continue
+		}
+		matches := len(versions) == 0
+		for _, v := range versions {
+			if entry.Version.Eq(v) {
+				matches = true
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+		sum, err := fileSHA256(entry.Path)
+		if err != nil {
+			return ans, err
+		}
+		ans.Checksums[entry.Version.Full()] = sum
+	}
+	return ans, nil
+}
+
+func showCacheEntries(entries []cacheEntry) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tKIND\tVERSION\tSIZE\tLAST USED")
+	var total int64
+	for _, entry := range entries {
+		fmt.Fprintf(
+			tw, "%s\t%s\t%s\t%s\t%s\n",
+			entry.Name, entry.Kind, entry.Version.Full(), formatSize(entry.Size),
+			entry.LastUsed.Format(time.DateTime),
+		)
+		total += entry.Size
+	}
+	tw.Flush()
+	fmt.Fprintf(os.Stderr, "\ntotal: %s\n", formatSize(total))
+}
+
+func reportRemoved(removed, skipped []cacheEntry) {
+	for _, entry := range skipped {
+		fmt.Fprintf(os.Stderr, "skipped %s (in use by another process)\n", entry.Path)
+	}
+	var total int64
+	for _, entry := range removed {
+		fmt.Fprintf(os.Stderr, "removed %s\n", entry.Path)
+		total += entry.Size
+	}
+	fmt.Fprintf(os.Stderr, "freed %s\n", formatSize(total))
+}
+
+func runCacheCommand(args []string, loadConf func() (*Conf, error)) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing cache subcommand (available: ls, clean, prune, checksums, path)")
+	}
+	conf, err := loadConf()
+	if err != nil {
+		return err
+	}
+	cacheDir, err := conf.CacheRoot()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "ls":
+		fset := flag.NewFlagSet("cache ls", flag.ContinueOnError)
+		asJSON := fset.Bool("json", false, "Print the list as JSON")
+		if err := fset.Parse(args[1:]); err != nil {
+			return err
+		}
+		entries, err := listCacheEntries(cacheDir)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(entries)
+		}
+		showCacheEntries(entries)
+		return nil
+	case "clean":
+		versions := make([]Version, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			v, err := ParseManateeVersion(arg)
+			if err != nil {
+				return err
+			}
+			versions = append(versions, v)
+		}
+		removed, skipped, err := cleanCache(cacheDir, versions)
+		reportRemoved(removed, skipped)
+		return err
+	case "prune":
+		fset := flag.NewFlagSet("cache prune", flag.ContinueOnError)
+		keep := fset.Int("keep", 1, "Number of most recently used versions to keep")
+		if err := fset.Parse(args[1:]); err != nil {
+			return err
+		}
+		removed, skipped, err := pruneCache(cacheDir, *keep)
+		reportRemoved(removed, skipped)
+		return err
+	case "checksums":
+		versions := make([]Version, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			v, err := ParseManateeVersion(arg)
+			if err != nil {
+				return err
+			}
+			versions = append(versions, v)
+		}
+		checksums, err := cachedArchiveChecksums(cacheDir, versions)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(
+			os.Stderr,
+			"note: the checksums describe the cached archives as they are - "+
+				"trust them only if the archives come from a verified mirror",
+		)
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		return enc.Encode(checksums)
+	case "path":
+		fmt.Println(cacheDir)
+		return nil
+	default:
+		return fmt.Errorf("unknown cache subcommand %s", args[0])
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func createCacheFixture(t *testing.T) string {
+	t.Helper()
+	cacheDir := t.TempDir()
+	now := time.Now()
+	items := []struct {
+		name    string
+		isDir   bool
+		lastUse time.Duration
+	}{
+		{"manatee-open-2.225.8", true, time.Hour},
+		{"manatee-open-2.225.8.tar.gz", false, time.Hour},
+		{"manatee-open-2.223.6.tar.gz", false, 48 * time.Hour},
+		{"manatee-open-2.208.0-cnc", true, 24 * time.Hour},
+		{"unknown-versions.jsonl", false, 0},
+	}
+	for _, item := range items {
+		p := filepath.Join(cacheDir, item.name)
+		if item.isDir {
+			if err := os.Mkdir(p, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(p, "VERSION"), []byte("2.225.8\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+		} else if err := os.WriteFile(p, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		tm := now.Add(-item.lastUse)
+		if err := os.Chtimes(p, tm, tm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cacheDir
+}
+
+func TestListCacheEntries(t *testing.T) {
+	entries, err := listCacheEntries(createCacheFixture(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %v", entries)
+	}
+	for _, entry := range entries {
+		if entry.Name == "manatee-open-2.225.8" && (entry.Kind != cacheKindSources || entry.Size != 8) {
+			t.Errorf("unexpected source tree entry %v", entry)
+		}
+	}
+	if entries, err := listCacheEntries(filepath.Join(t.TempDir(), "missing")); err != nil || len(entries) != 0 {
+		t.Errorf("missing cache should be empty, got %v, %v", entries, err)
+	}
+}
+
+func TestPruneCache(t *testing.T) {
+	cacheDir := createCacheFixture(t)
+	removed, _, err := pruneCache(cacheDir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("expected 2 removed entries, got %v", removed)
+	}
+	entries, err := listCacheEntries(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Version.Full() != "2.225.8" {
+			t.Errorf("entry %s should have been pruned", entry.Name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "unknown-versions.jsonl")); err != nil {
+		t.Error("unrelated files must be kept")
+	}
+}
+
+func TestCleanCache(t *testing.T) {
+	cacheDir := createCacheFixture(t)
+	removed, _, err := cleanCache(cacheDir, []Version{{2, 225, 8, ""}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("expected 2 removed entries, got %v", removed)
+	}
+	if _, _, err := cleanCache(cacheDir, []Version{}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := listCacheEntries(cacheDir); len(entries) != 0 {
+		t.Errorf("expected empty cache, got %v", entries)
+	}
+}
+
+func TestCleanCacheSkipsLockedEntries(t *testing.T) {
+	cacheDir := createCacheFixture(t)
+	lock, err := AcquireFileLock(manateeSrcLockPath(cacheDir, Version{2, 225, 8, ""}, ""), time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, skipped, err := cleanCache(cacheDir, []Version{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 || len(skipped) != 2 {
+		t.Errorf("expected 2 removed and 2 skipped entries, got %v, %v", removed, skipped)
+	}
+	for _, entry := range skipped {
+		if entry.Version.Full() != "2.225.8" {
+			t.Errorf("unexpected skipped entry %s", entry.Name)
+		}
+	}
+	lock.Release()
+	removed, skipped, err = pruneCache(cacheDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 || len(skipped) != 0 {
+		t.Errorf("expected 2 removed entries once unlocked, got %v, %v", removed, skipped)
+	}
+}
+
+func TestCleanCacheRemovesPartialDownloads(t *testing.T) {
+	cacheDir := createCacheFixture(t)
+	ver := Version{2, 223, 6, ""}
+	target := manateeArchivePath(cacheDir, ver)
+	partPath := downloadPartPath("https://mirror/manatee-open-2.223.6.tar.gz", target)
+	if err := os.WriteFile(partPath, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := listCacheEntries(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, entry := range entries {
+		if entry.Path == partPath {
+			found = entry.Kind == cacheKindPartial && entry.Version.Eq(ver) && entry.Size == 7
+		}
+	}
+	if !found {
+		t.Errorf("partial download should be listed, got %v", entries)
+	}
+	removed, _, err := cleanCache(cacheDir, []Version{ver})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("expected the archive and the partial download to be removed, got %v", removed)
+	}
+	if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+		t.Error("partial download should be removed")
+	}
+}
+
+func TestCacheRoot(t *testing.T) {
+	xdg := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", xdg)
+	conf := NewConf()
+	if root, err := conf.CacheRoot(); err != nil || root != filepath.Join(xdg, "manabuild") {
+		t.Errorf("unexpected default cache root %s (%v)", root, err)
+	}
+	conf.CacheDir = "/srv/manabuild-cache"
+	if root, _ := conf.CacheRoot(); root != conf.CacheDir {
+		t.Errorf("configured cache root should be used, got %s", root)
+	}
+}
+
+func TestCachedArchiveChecksums(t *testing.T) {
+	cacheDir := createCacheFixture(t)
+	dataSum := "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7" // sha256("data")
+	checksums, err := cachedArchiveChecksums(cacheDir, []Version{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checksums.Checksums) != 2 || checksums.Checksums["2.225.8"] != dataSum ||
+		checksums.Checksums["2.223.6"] != dataSum {
+		t.Errorf("unexpected checksums %v", checksums.Checksums)
+	}
+	checksums, err = cachedArchiveChecksums(cacheDir, []Version{{2, 223, 6, ""}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checksums.Checksums) != 1 || checksums.Checksums["2.223.6"] != dataSum {
+		t.Errorf("unexpected checksums %v", checksums.Checksums)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	checksumSourceBuiltin = "built-in"
+)
+
+var (
+	ErrNoChecksum       = errors.New("no checksum known")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+//go:embed checksums.json
+var builtinChecksumsData []byte
+
+// checksumsFile is a format of the built-in checksums.json. To update
+// the file, download archives of all the KnownVersions from a verified
+// mirror, check them against the upstream and run `manabuild cache checksums`
+// (it just hashes the cached archives, it does not verify them in any way).
+type checksumsFile struct {
+	Checksums map[string]string `json:"checksums"`
+}
+
+type checksumEntry struct {
+	sum    string
+	source string
+}
+
+// ChecksumRegistry contains SHA-256 checksums of Manatee source
+// archives identified by respective versions (incl. variants)
+type ChecksumRegistry struct {
+	checksums map[string]checksumEntry
+}
+
+func normalizeChecksum(sum string) (string, error) {
+	sum = strings.ToLower(strings.TrimSpace(sum))
+	if len(sum) != 2*sha256.Size {
+		return "", fmt.Errorf("invalid SHA-256 checksum %s: expected %d hex digits", sum, 2*sha256.Size)
+	}
+	if _, err := hex.DecodeString(sum); err != nil {
+		return "", fmt.Errorf("invalid SHA-256 checksum %s: %w", sum, err)
+	}
+	return sum, nil
+}
+
+func (cr *ChecksumRegistry) add(checksums map[string]string, source string) error {
+	errs := make([]error, 0, len(checksums))
+	for k, sum := range checksums {
+		ver, err := ParseManateeVersion(k)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("checksums: %w", err))
+			continue
+		}
+		nsum, err := normalizeChecksum(sum)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("checksums[%s]: %w", k, err))
+			continue
+		}
+		cr.checksums[ver.Full()] = checksumEntry{sum: nsum, source: source}
+	}
+	return errors.Join(errs...)
+}
+
+// Lookup returns a checksum of the source archive of a specified
+// version along with a description of where it has been defined.
+func (cr *ChecksumRegistry) Lookup(ver Version) (sum string, source string, ok bool) {
+	entry, ok := cr.checksums[ver.Full()]
+	return entry.sum, entry.source, ok
+}
+
+// Verify compares a checksum of an archive with the registered one.
+// In case there is no registered checksum, ErrNoChecksum is returned.
+func (cr *ChecksumRegistry) Verify(ver Version, archivePath string) error {
+	expected, source, ok := cr.Lookup(ver)
+	if !ok {
+		return fmt.Errorf("cannot verify %s: %w for %s", archivePath, ErrNoChecksum, ver.Full())
+	}
+	actual, err := fileSHA256(archivePath)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf(
+			"%w: %s has SHA-256 %s, expected %s (%s)", ErrChecksumMismatch, archivePath, actual, expected, source)
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to calculate checksum of %s: %w", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to calculate checksum of %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// LoadChecksums creates a registry of archive checksums. Checksums
+// defined in the configuration take precedence over the built-in ones.
+func LoadChecksums(conf *Conf) (*ChecksumRegistry, error) {
+	ans := &ChecksumRegistry{checksums: make(map[string]checksumEntry)}
+	var builtin checksumsFile
+	if err := decodeStrict(builtinChecksumsData, &builtin); err != nil {
+		return ans, fmt.Errorf("failed to load built-in checksums: %w", err)
+	}
+	if err := ans.add(builtin.Checksums, checksumSourceBuiltin); err != nil {
+		return ans, fmt.Errorf("invalid built-in checksums: %w", err)
+	}
+	if err := ans.add(conf.Checksums, conf.Origin("checksums")); err != nil {
+		return ans, err
+	}
+	return ans, nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/czcorpus/cnc-gokit/fs"
+)
+
+func writeArchiveFixture(t *testing.T, content string) (string, string) {
+	t.Helper()
+	archivePath := filepath.Join(t.TempDir(), "manatee-open-2.225.8.tar.gz")
+	if err := os.WriteFile(archivePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	return archivePath, hex.EncodeToString(sum[:])
+}
+
+func TestChecksumVerify(t *testing.T) {
+	archivePath, sum := writeArchiveFixture(t, "archive data")
+	ver := Version{2, 225, 8, ""}
+	conf := NewConf()
+	conf.Checksums = map[string]string{"2.225.8": strings.ToUpper(sum)}
+	checksums, err := LoadChecksums(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checksums.Verify(ver, archivePath); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := checksums.Verify(Version{2, 225, 8, "cnc"}, archivePath); !errors.Is(err, ErrNoChecksum) {
+		t.Errorf("expected ErrNoChecksum for a different variant, got %v", err)
+	}
+	if err := os.WriteFile(archivePath, []byte("tampered data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checksums.Verify(ver, archivePath); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
+
+func TestBuiltinChecksumsCoverKnownVersions(t *testing.T) {
+	checksums, err := LoadChecksums(NewConf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kv := range KnownVersions {
+		ver, err := ParseManateeVersion(kv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, ok := checksums.Lookup(ver); !ok {
+			t.Errorf("no built-in checksum of %s (see checksums.json)", ver.Full())
+		}
+	}
+}
+
+func TestVerifyManateeArchive(t *testing.T) {
+	archivePath, _ := writeArchiveFixture(t, "archive data")
+	// a version without any built-in checksum
+	ver := Version{9, 0, 0, ""}
+	checksums, err := LoadChecksums(NewConf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyManateeArchive(ver, archivePath, checksums, false); err != nil {
+		t.Errorf("missing checksum should not be an error by default, got %v", err)
+	}
+	if err := verifyManateeArchive(ver, archivePath, checksums, true); !errors.Is(err, ErrNoChecksum) {
+		t.Errorf("expected ErrNoChecksum, got %v", err)
+	}
+	// known versions must be always verified
+	noChecksums := &ChecksumRegistry{checksums: make(map[string]checksumEntry)}
+	if err := verifyManateeArchive(Version{2, 225, 8, ""}, archivePath, noChecksums, false); !errors.Is(err, ErrNoChecksum) {
+		t.Errorf("missing checksum of a known version should be an error, got %v", err)
+	}
+
+	conf := NewConf()
+	conf.Checksums = map[string]string{"9.0.0": strings.Repeat("0", 64)}
+	checksums, err = LoadChecksums(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyManateeArchive(ver, archivePath, checksums, false); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if fs.PathExists(archivePath) {
+		t.Error("archive with mismatching checksum should be removed")
+	}
+}
+
+func TestInvalidChecksums(t *testing.T) {
+	tests := []map[string]string{
+		{"2.225.8": "abc"},
+		{"2.225.8": strings.Repeat("x", 64)},
+		{"foo": strings.Repeat("0", 64)},
+	}
+	for _, checksums := range tests {
+		conf := NewConf()
+		conf.Checksums = checksums
+		if err := conf.Validate(); err == nil {
+			t.Errorf("expected validation error for %v", checksums)
+		}
+	}
+}
@@ -28,7 +28,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/czcorpus/cnc-gokit/collections"
 	"github.com/fatih/color"
 )
 
@@ -47,13 +46,138 @@ var (
 	}
 )
 
+// resolveManateeVersion determines a Manatee version to be used
+// for build based on a configured version or version constraint
+// (spec) and on the autodetected version. In case of a constraint,
+// the autodetected version is preferred, then other installed versions
+// and finally the known (i.e. downloadable) ones.
+//
+// In case allowUnknown is true, detected and installed versions
+// not listed in KnownVersions are considered too.
+func resolveManateeVersion(spec string, detected Version, manateeLib string, allowUnknown bool) (Version, error) {
+	if spec == "" {
+		return detected, nil
+	}
+	constr, err := ParseVersionConstraint(spec)
+	if err != nil {
+		return Version{}, err
+	}
+	if v, ok := constr.ExactVersion(); ok {
+		return v, nil
+	}
+	if !detected.IsZero() && constr.Check(detected) &&
+		(allowUnknown || isKnownVersion(detected, KnownVersions)) {
+		return detected, nil
+	}
+	installed := make([]Version, 0, 10)
+	for _, v := range findInstalledManateeVersions(manateeLib) {
+		if allowUnknown || isKnownVersion(v, KnownVersions) {
+			installed = append(installed, v)
+		}
+	}
+	if v, ok := constr.Best(installed); ok {
+		return v, nil
+	}
+	known := make([]Version, 0, len(KnownVersions))
+	for _, kv := range KnownVersions {
+		if v, err := ParseManateeVersion(kv); err == nil {
+			known = append(known, v)
+		}
+	}
+	if v, ok := constr.Best(known); ok {
+		return v, nil
+	}
+	return Version{}, fmt.Errorf(
+		"no installed or supported Manatee version satisfies %s (supported: %s)",
+		constr, strings.Join(KnownVersions, ", "))
+}
+
+// verifyProvidedManatee checks whether a version detected in
+// a user-provided Manatee component (sources or library) matches
+// the required version. In case of a mismatch, ErrVersionMismatch
+// is returned unless `force` is set. In case isSrcTree is true and
+// the sources do not specify a variant, only numeric versions are
+// compared (source trees of some variants do not carry the variant
+// suffix). An undetectable version produces just a warning.
+func verifyProvidedManatee(
+	ctx *OperationSequence,
+	what string,
+	isSrcTree bool,
+	found Version,
+	detectErr error,
+	required Version,
+	force bool,
+) error {
+	if detectErr != nil {
+		ctx.WithPausedOutput(func() {
+			color.New(color.FgHiYellow).Fprintf(
+				os.Stderr, "\nWARNING: cannot verify version of %s: %s\n", what, detectErr)
+		})
+		return nil
+	}
+	matches := found.Eq(required)
+	if isSrcTree && found.Variant == VariantUpstream {
+		matches = found.Semver() == required.Semver()
+	}
+	if matches {
+		ctx.WithPausedOutput(func() {
+			fmt.Fprintf(os.Stderr, "\nVerified %s: %s\n", what, found.Full())
+		})
+		return nil
+	}
+	if force {
+		ctx.WithPausedOutput(func() {
+			color.New(color.FgHiRed).Fprintf(
+				os.Stderr,
+				"\nWARNING: %s has version %s but %s is required (ignored due to -force)\n",
+				what, found.Full(), required.Full(),
+			)
+		})
+		return nil
+	}
+	return fmt.Errorf(
+		"%w: %s has version %s but %s is required", ErrVersionMismatch, what, found.Full(), required.Full())
+}
+
+// failOnVersionMismatch stops the operation sequence in case
+// verifyProvidedManatee has found a mismatch
+func failOnVersionMismatch(ctx *OperationSequence, err error) {
+	if err == nil {
+		return
+	}
+	ctx.Fail(func() {
+		fmt.Fprintf(os.Stderr, "\nERROR: %s.\n", err)
+		fmt.Fprintln(
+			os.Stderr,
+			"Mixing Manatee headers and library of different versions leads to link errors or crashes.")
+		fmt.Fprintln(os.Stderr, "To ignore the mismatch, run the script with -force.")
+	})
+}
+
 func showVersionMismatch(found, expected Version) {
-	fmt.Fprintf(os.Stderr, "\nERROR: Found Manatee %s, you require %s.\n", found.Semver(), expected.Semver())
+	fmt.Fprintf(os.Stderr, "\nERROR: Found Manatee %s, you require %s.\n", found.Full(), expected.Full())
+	if found.Semver() == expected.Semver() {
+		fmt.Fprintf(
+			os.Stderr,
+			"\nThe versions differ in variant (%s vs. %s) which are not mutually compatible.\n",
+			found.ManateeVariant().Description, expected.ManateeVariant().Description,
+		)
+	}
 	fmt.Fprintln(os.Stderr, "\nA) If you prefer a different installed version of Manatee")
 	fmt.Fprintln(os.Stderr, "then please specify a path where a respective libmanatee.so")
-	fmt.Fprintf(os.Stderr, "can be found (manabuild %s --manatee-lib /path/to/libmanatee.so/dir\n\n", expected.Semver())
+	fmt.Fprintf(os.Stderr, "can be found (manabuild %s --manatee-lib /path/to/libmanatee.so/dir\n\n", expected.Full())
 	fmt.Fprintln(os.Stderr, "B) If you want to use the detected installed version then run")
-	fmt.Fprintf(os.Stderr, "this script with proper version (manabuild %s)", found.Semver())
+	fmt.Fprintf(os.Stderr, "this script with proper version (manabuild %s)", found.Full())
+}
+
+func showRejectedLibs(rejected []rejectedManateeLib) {
+	if len(rejected) == 0 {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "\nSkipped libraries:")
+	for _, r := range rejected {
+		fmt.Fprintf(os.Stderr, "  %s/libmanatee.so: %s\n", r.Dir, r.Reason)
+	}
 }
 
 func mkHeader() {
@@ -118,30 +242,133 @@ func generateBootstrapScript(
 	return nil
 }
 
+// applyEnvToFlags sets all the flags not specified on the command
+// line by respective MANABUILD_* environment variables (if defined).
+// Such flags are then considered as explicitly set. The function
+// returns a map of flags set this way along with variable names.
+func applyEnvToFlags(fset *flag.FlagSet, env EnvironmentVars) (map[string]string, error) {
+	explicit := make(map[string]bool)
+	fset.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	fromEnv := make(map[string]string)
+	var err error
+	fset.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] {
+			return
+		}
+		envName := EnvVarName(f.Name)
+		if v, ok := env[envName]; ok {
+			if err2 := fset.Set(f.Name, v); err2 != nil {
+				err = fmt.Errorf("invalid value of %s: %w", envName, err2)
+			}
+			fromEnv[f.Name] = envName
+		}
+	})
+	return fromEnv, err
+}
+
+// defineConfFlags defines flags overriding respective
+// config values (see applyFlagsToConf)
+func defineConfFlags(fset *flag.FlagSet) {
+	fset.Bool("test", false, "Specify whether to run unit tests")
+	fset.String("cmd-dir", "", "A subdirectory of `cmd` to be used for build.")
+	fset.Bool("with-pcre2", false, "Specify whether to use PCRE2 for build")
+	fset.String("manatee-src", "", "Location of Manatee source files")
+	fset.String("manatee-lib", "", "Location of libmanatee.so")
+	fset.Bool("allow-unknown-version", false, "Try to build against a Manatee version not listed among the supported ones")
+}
+
+// applyFlagsToConf overrides config values by explicitly set flags
+// (incl. the ones set via environment variables - see applyEnvToFlags)
+// and records where the values come from.
+func applyFlagsToConf(conf *Conf, fset *flag.FlagSet, fromEnv map[string]string) {
+	fset.Visit(func(f *flag.Flag) {
+		origin := "flag -" + f.Name
+		if envName, ok := fromEnv[f.Name]; ok {
+			origin = "environment " + envName
+		}
+		value := f.Value.(flag.Getter).Get()
+		switch f.Name {
+		case "test":
+			conf.RunTests = value.(bool)
+			conf.SetOrigin("runTests", origin)
+		case "cmd-dir":
+			conf.CmdDir = value.(string)
+			conf.SetOrigin("cmdDir", origin)
+		case "with-pcre2":
+			conf.WithPCRE2 = value.(bool)
+			conf.SetOrigin("withPcre2", origin)
+		case "manatee-src":
+			conf.ManateeSrc = value.(string)
+			conf.SetOrigin("manateeSrc", origin)
+		case "manatee-lib":
+			conf.ManateeLib = value.(string)
+			conf.SetOrigin("manateeLib", origin)
+		case "allow-unknown-version":
+			conf.AllowUnknownVersion = value.(bool)
+			conf.SetOrigin("allowUnknownVersion", origin)
+		}
+	})
+}
+
+// loadConfLayers loads config files applicable to a project and
+// applies the overriding layers (profile, environment variables and
+// flags). The resulting configuration is validated.
+func loadConfLayers(
+	projectPath string,
+	profile string,
+	env EnvironmentVars,
+	fset *flag.FlagSet,
+	fromEnv map[string]string,
+) (*Conf, error) {
+	conf, err := LoadConfig(projectPath)
+	if err != nil {
+		return conf, err
+	}
+	if err := conf.ApplyProfileAndEnv(profile, env); err != nil {
+		return conf, err
+	}
+	// explicitly set flags (incl. the ones set via env. variables)
+	// take precedence over config, profile and config env. variables
+	applyFlagsToConf(conf, fset, fromEnv)
+	// all the layers must be applied before validation
+	// (e.g. an environment variable may break a valid config)
+	if err := conf.Validate(); err != nil {
+		return conf, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return conf, nil
+}
+
 func main() {
 	flag.Usage = func() {
 		fmt.Fprint(
 			os.Stderr,
 			"Manabuild - a tool for building Go programs with Manatee-open dependency\n",
-			fmt.Sprintf("usage: %s [binary name] (in case .manabuild.json or -no-build is enabled)\n", filepath.Base(os.Args[0])),
-			fmt.Sprintf("       %s [binary name] [version]\n", filepath.Base(os.Args[0])),
+			fmt.Sprintf("usage: %s (in case .manabuild.json or -no-build is enabled)\n", filepath.Base(os.Args[0])),
+			fmt.Sprintf("       %s [binary name]\n", filepath.Base(os.Args[0])),
+			fmt.Sprintf("       %s [binary name] [version or constraint, e.g. 2.225.8, >=2.208, ~2.225, 2.223.x]\n", filepath.Base(os.Args[0])),
+			fmt.Sprintf("       %s init [-overwrite] [-dry-run]\n", filepath.Base(os.Args[0])),
+			fmt.Sprintf("       %s config show|schema|validate [file...]\n", filepath.Base(os.Args[0])),
+			fmt.Sprintf("       %s versions [-json]\n", filepath.Base(os.Args[0])),
+			fmt.Sprintf("       %s cache ls [-json]|clean [version...]|prune [-keep N]|checksums [version...]|path\n", filepath.Base(os.Args[0])),
 			fmt.Sprintf("       %s version", filepath.Base(os.Args[0])),
-			"\n")
+			"\n\nAll the flags and config keys can be also set via MANABUILD_* environment variables\n",
+			"(e.g. -manatee-lib => MANABUILD_MANATEE_LIB, runTests => MANABUILD_RUN_TESTS)\n")
 		flag.PrintDefaults()
 	}
-	conf := new(Conf)
 	workingDir := flag.String("project-path", ".", "A path where a target project is located")
-	err := TryConfig(*workingDir, conf)
-	if err != nil && err != ErrNoConfig {
-		log.Fatal(err)
-	}
-	shouldRunTests := flag.Bool("test", false, "Specify whether to run unit tests")
-	buildCmdDir := flag.String("cmd-dir", "", "A subdirectory of `cmd` to be used for build.")
+	defineConfFlags(flag.CommandLine)
 	noBuild := flag.Bool("no-build", false, "Just check and prepare Manatee sources and define CGO variables")
-	withPcre2 := flag.Bool("with-pcre2", false, "Specify whether to use PCRE2 for build")
-	manateeSrc := flag.String("manatee-src", "", "Location of Manatee source files")
-	manateeLib := flag.String("manatee-lib", "", "Location of libmanatee.so")
+	profile := flag.String("profile", "", "A build profile (as defined in .manabuild.json) to be applied")
+	force := flag.Bool("force", false, "Continue even if provided Manatee sources or library do not match required version")
+	selectedTargets := flag.String("only", "", "A comma-separated list of configured targets to build (default: all)")
 	flag.Parse()
+	envFlags, err := applyEnvToFlags(flag.CommandLine, GetEnvironmentVars())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
 	if flag.Arg(0) == "version" {
 		fmt.Fprintf(
@@ -153,7 +380,70 @@ func main() {
 		return
 	}
 
-	if !conf.IsLoaded() && !*noBuild && (flag.NArg() < 1 || flag.NArg() > 2) {
+	loadConf := func() (*Conf, error) {
+		return loadConfLayers(*workingDir, *profile, GetEnvironmentVars(), flag.CommandLine, envFlags)
+	}
+
+	if flag.Arg(0) == "init" {
+		if err := runInitCommand(flag.Args()[1:], *workingDir, flag.Lookup("manatee-lib").Value.String()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "config" {
+		if err := runConfigCommand(flag.Args()[1:], *workingDir, loadConf); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "cache" {
+		if err := runCacheCommand(flag.Args()[1:], loadConf); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "versions" {
+		if err := runVersionsCommand(flag.Args()[1:], loadConf); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	conf, err := loadConf()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if flag.Arg(0) != "" {
+		// an explicit binary name replaces configured targets
+		conf.TargetBinaryName = flag.Arg(0)
+		conf.Targets = []BuildTarget{}
+		conf.SetOrigin("targetBinaryName", "command line argument")
+		conf.SetOrigin("targets", "command line argument")
+	}
+	if flag.Arg(1) != "" {
+		conf.ManateeVersion = flag.Arg(1)
+		conf.SetOrigin("manateeVersion", "command line argument")
+	}
+
+	var targetNames []string
+	if *selectedTargets != "" {
+		targetNames = strings.Split(*selectedTargets, ",")
+	}
+	targets, err := conf.BuildTargets(targetNames)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to determine build targets: %s\n", err)
+		os.Exit(1)
+	}
+
+	if flag.NArg() > 2 || !*noBuild && len(targets) == 0 {
 		flag.Usage()
 		os.Exit(1)
 		return
@@ -161,13 +451,19 @@ func main() {
 
 	mkHeader()
 
-	if conf.SrcPath() != "" {
-		color.New(color.FgHiYellow).Fprintf(os.Stderr, "\n \u24D8  Using %s\n", conf.SrcPath())
+	for _, srcPath := range conf.SrcPaths() {
+		color.New(color.FgHiYellow).Fprintf(os.Stderr, "\n \u24D8  Using %s\n", srcPath)
 	}
 
 	var shouldGenerateRunScript bool
-	detectedVersion, err := AutodetectManateeVersion(*manateeLib, KnownVersions)
-	if err != nil {
+	detectedVersion, err := AutodetectManateeVersion(conf.ManateeLib, KnownVersions)
+	if conf.ManateeVersion != "" {
+		// the detected version is just a hint for version constraints
+		if err != nil {
+			detectedVersion = Version{}
+		}
+
+	} else if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to find manatee-open or determine its version: %s\n", err)
 		os.Exit(1)
 
@@ -175,12 +471,20 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Autodetection has not found any suitable Manatee version. Please select one manually\n")
 		os.Exit(1)
 	}
-	specifiedVersion := detectedVersion
-	if flag.Arg(1) != "" {
-		specifiedVersion, err = ParseManateeVersion(flag.Arg(1))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to parse specified version")
-			os.Exit(1)
+	specifiedVersion, err := resolveManateeVersion(
+		conf.ManateeVersion, detectedVersion, conf.ManateeLib, conf.AllowUnknownVersion)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to determine Manatee version: %s\n", err)
+		os.Exit(1)
+	}
+	if conf.ManateeVersion != "" {
+		constr, _ := ParseVersionConstraint(conf.ManateeVersion) // already validated
+		if _, isExact := constr.ExactVersion(); !isExact {
+			color.New(color.FgHiYellow).Fprintf(
+				os.Stderr,
+				"\n \u24D8  Version constraint %s satisfied by %s\n",
+				conf.ManateeVersion, specifiedVersion.Full(),
+			)
 		}
 
 	} else {
@@ -190,18 +494,64 @@ func main() {
 			detectedVersion,
 		)
 	}
-	if flag.Arg(0) != "" {
-		conf.TargetBinaryName = flag.Arg(0)
-	}
-
-	if !collections.SliceContains(KnownVersions, specifiedVersion.Semver()) {
+	isUnknownVersion := !isKnownVersion(specifiedVersion, KnownVersions)
+	if isUnknownVersion && !conf.AllowUnknownVersion {
 		fmt.Fprintf(
 			os.Stderr,
-			"Unsupported version: %s. Please use one of: %s\n",
+			"Unsupported version: %s. Please use one of: %s (or try -allow-unknown-version)\n",
 			specifiedVersion, strings.Join(KnownVersions, ", "),
 		)
 		os.Exit(1)
 	}
+	recipes, err := LoadRecipes(conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load build recipes: %s\n", err)
+		os.Exit(1)
+	}
+	cacheDir, err := conf.CacheRoot()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	downloader, err := conf.NewDownloader()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	checksums, err := LoadChecksums(conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load archive checksums: %s\n", err)
+		os.Exit(1)
+	}
+	lockTimeout, err := conf.SrcLockTimeout()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var recipe BuildRecipe
+	if isUnknownVersion {
+		recipe, err = recipes.FindClosest(specifiedVersion, KnownVersions)
+
+	} else {
+		recipe, err = recipes.Find(specifiedVersion)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	color.New(color.FgHiYellow).Fprintf(
+		os.Stderr,
+		"\n \u24D8  Using build recipe for %s (%s)\n",
+		recipe.Versions, recipe.Source(),
+	)
+	if isUnknownVersion {
+		color.New(color.FgHiRed).Fprintf(
+			os.Stderr,
+			"\n\u26A0  WARNING: %s is not among the supported versions (%s).\n"+
+				"   Trying the closest build recipe - the build may fail or produce a broken binary.\n",
+			specifiedVersion, strings.Join(KnownVersions, ", "),
+		)
+	}
 
 	timeLocation, err := time.LoadLocation("Europe/Prague")
 	if err != nil {
@@ -209,10 +559,57 @@ func main() {
 		os.Exit(1)
 	}
 	seq := NewOperationSequence(timeLocation)
+	recordOutcome := func(failedOperation string) {
+		if !isUnknownVersion {
+			return
+		}
+		projectPath, err := filepath.Abs(*workingDir)
+		if err != nil {
+			projectPath = *workingDir
+		}
+		logPath, err := recordUnknownVersionOutcome(cacheDir, unknownVersionOutcome{
+			Time:            time.Now().In(timeLocation),
+			Version:         specifiedVersion.Full(),
+			Recipe:          recipe.Versions,
+			RecipeSource:    recipe.Source(),
+			Project:         projectPath,
+			Success:         failedOperation == "",
+			FailedOperation: failedOperation,
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+
+		} else {
+			color.New(color.FgHiYellow).Fprintf(
+				os.Stderr, "\n \u24D8  Outcome of the %s build recorded in %s\n", specifiedVersion.Full(), logPath)
+		}
+	}
+	seq.OnFail(recordOutcome)
+
+	// the lock is held until the project is built against the sources
+	// (in case of a failure, it is released by the OS on exit)
+	var srcLock *FileLock
+	seq.RunOperation("locking manatee-open sources", func(ctx *OperationSequence) {
+		srcLock, err = lockManateeSrc(ctx, cacheDir, specifiedVersion, conf.ManateeSrc, lockTimeout)
+		if err != nil {
+			ctx.Fail(func() {
+				fmt.Fprintln(os.Stderr, err)
+			})
+		}
+	})
 
 	seq.RunOperation("searching for manatee-open", func(ctx *OperationSequence) {
-		if *manateeSrc == "" {
-			*manateeSrc, err = downloadManateeSrc(specifiedVersion)
+		if conf.ManateeSrc == "" {
+			conf.ManateeSrc, err = downloadManateeSrc(
+				ctx,
+				downloader,
+				specifiedVersion,
+				recipe,
+				cacheDir,
+				conf.Mirrors,
+				checksums,
+				conf.RequireChecksums,
+			)
 			if err != nil {
 				ctx.Fail(func() {
 					fmt.Fprintln(os.Stderr, err)
@@ -220,59 +617,67 @@ func main() {
 			}
 
 		} else {
-			ctx.WithPausedOutput(func() {
-				fmt.Fprintf(
-					os.Stderr,
-					"\nAssuming that provided Manatee src path matches required version %s",
-					specifiedVersion.Semver(),
-				)
-			})
+			srcVersion, err := srcManateeVersion(conf.ManateeSrc)
+			err = verifyProvidedManatee(
+				ctx,
+				fmt.Sprintf("Manatee sources in %s", conf.ManateeSrc),
+				true,
+				srcVersion,
+				err,
+				specifiedVersion,
+				*force,
+			)
+			failOnVersionMismatch(ctx, err)
 		}
 
-		if *manateeLib == "" {
-			*manateeLib = findManatee(specifiedVersion)
-			if *manateeLib == "" {
+		if conf.ManateeLib == "" {
+			var rejected []rejectedManateeLib
+			conf.ManateeLib, rejected = findManatee(specifiedVersion)
+			if conf.ManateeLib == "" {
 				ctx.Fail(func() {
-					fmt.Fprintln(
+					fmt.Fprintf(
 						os.Stderr,
-						"Manatee not found in system searched paths. Please run the script with --manatee-lib argument")
-				})
-			}
-			if !specifiedVersion.Eq(detectedVersion) {
-
-				ctx.Fail(func() {
-					showVersionMismatch(detectedVersion, specifiedVersion)
+						"Manatee %s not found in system searched paths.\n", specifiedVersion.Full())
+					showRejectedLibs(rejected)
+					if !detectedVersion.IsZero() && !detectedVersion.Eq(specifiedVersion) {
+						showVersionMismatch(detectedVersion, specifiedVersion)
+
+					} else {
+						fmt.Fprintln(os.Stderr, "\nPlease run the script with --manatee-lib argument")
+					}
 				})
 
 			} else {
 				ctx.WithPausedOutput(func() {
 					fmt.Fprintf(
 						os.Stderr,
-						"\nUsing system-installed %s\n",
-						detectedVersion.Semver(),
+						"\nUsing system-installed %s from %s\n",
+						specifiedVersion.Full(),
+						conf.ManateeLib,
 					)
+					if len(rejected) > 0 {
+						showRejectedLibs(rejected)
+					}
 				})
 			}
 
 		} else {
-			ctx.WithPausedOutput(func() {
-				fmt.Fprintf(
-					os.Stderr,
-					"\nAssuming that provided %s/libmanatee.so matches required version %s",
-					*manateeLib, specifiedVersion.Semver(),
-				)
-			})
+			libPath := filepath.Join(conf.ManateeLib, "libmanatee.so")
+			libVersion, err := libManateeVersion(libPath)
+			err = verifyProvidedManatee(ctx, libPath, false, libVersion, err, specifiedVersion, *force)
+			failOnVersionMismatch(ctx, err)
 		}
 
-		if !strings.HasPrefix(*manateeLib, "/usr/local/lib") { // TODO maybe we should test more paths known by LD
-			shouldGenerateRunScript = true
-		}
+		shouldGenerateRunScript = !IsOnDefaultLoaderPath(conf.ManateeLib)
 	})
 
-	clearPreviousBinaries(*workingDir, conf.TargetBinaryName)
+	outputDir := filepath.Join(*workingDir, conf.OutputDir)
+	for _, target := range targets {
+		clearPreviousBinaries(outputDir, target.BinaryName)
+	}
 
 	seq.RunOperation("preparing manatee-open sources", func(ctx *OperationSequence) {
-		err = initManateeSources(specifiedVersion, *manateeSrc, *withPcre2)
+		err = initManateeSources(specifiedVersion, recipe, conf.ManateeSrc, conf.WithPCRE2)
 		if err != nil {
 			ctx.Fail(func() {
 				fmt.Fprintf(os.Stderr, "Failed to init manatee-open sources: %s", err)
@@ -287,13 +692,14 @@ func main() {
 	seq.RunOperation(msg, func(ctx *OperationSequence) {
 		err = buildProject(
 			ctx,
-			specifiedVersion,
+			recipe,
 			*workingDir,
-			*manateeSrc,
-			*manateeLib,
-			*shouldRunTests,
-			conf.TargetBinaryName,
-			*buildCmdDir,
+			conf.ManateeSrc,
+			conf.ManateeLib,
+			conf.RunTests,
+			conf.StripSymbols,
+			conf.OutputDir,
+			targets,
 			*noBuild,
 		)
 		if err != nil {
@@ -302,16 +708,27 @@ func main() {
 			})
 		}
 	})
+	if err := srcLock.Release(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
 
 	if !*noBuild {
 		seq.RunOperation("generating executable", func(ctx *OperationSequence) {
-			generateBootstrapScript(
-				ctx,
-				shouldGenerateRunScript,
-				*manateeLib,
-				*workingDir,
-				conf.TargetBinaryName,
-			)
+			for _, target := range targets {
+				err := generateBootstrapScript(
+					ctx,
+					shouldGenerateRunScript,
+					conf.ManateeLib,
+					outputDir,
+					target.BinaryName,
+				)
+				if err != nil {
+					ctx.Fail(func() {
+						fmt.Fprintf(os.Stderr, "Failed to generate executable %s: %s\n", target.BinaryName, err)
+					})
+				}
+			}
 		})
 	}
+	recordOutcome("")
 }
@@ -0,0 +1,230 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// createLibFixture creates a directory containing a copy
+// of a testdata library named libmanatee.so
+func createLibFixture(t *testing.T, fixture string) string {
+	t.Helper()
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	libDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(libDir, "libmanatee.so"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return libDir
+}
+
+// newConfFlagSet creates a flag set with the config-related
+// flags of manabuild and parses the provided arguments
+func newConfFlagSet(t *testing.T, args ...string) *flag.FlagSet {
+	t.Helper()
+	fset := flag.NewFlagSet("manabuild", flag.ContinueOnError)
+	defineConfFlags(fset)
+	if err := fset.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return fset
+}
+
+func TestApplyFlagsToConf(t *testing.T) {
+	fset := newConfFlagSet(t, "-manatee-lib", "/opt/manatee/lib", "-test=false")
+	env := EnvironmentVars{
+		"MANABUILD_MANATEE_LIB": "/usr/local/lib",
+		"MANABUILD_WITH_PCRE2":  "true",
+		"MANABUILD_CMD_DIR":     "server",
+	}
+	fromEnv, err := applyEnvToFlags(fset, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := NewConf()
+	conf.RunTests = true
+	conf.SetOrigin("runTests", "/project/.manabuild.json")
+	applyFlagsToConf(conf, fset, fromEnv)
+	tests := []struct {
+		key    string
+		value  any
+		want   any
+		origin string
+	}{
+		{"manateeLib", conf.ManateeLib, "/opt/manatee/lib", "flag -manatee-lib"},
+		{"runTests", conf.RunTests, false, "flag -test"},
+		{"withPcre2", conf.WithPCRE2, true, "environment MANABUILD_WITH_PCRE2"},
+		{"cmdDir", conf.CmdDir, "server", "environment MANABUILD_CMD_DIR"},
+		{"manateeSrc", conf.ManateeSrc, "", OriginDefault},
+		{"allowUnknownVersion", conf.AllowUnknownVersion, false, OriginDefault},
+	}
+	for _, tt := range tests {
+		if tt.value != tt.want || conf.Origin(tt.key) != tt.origin {
+			t.Errorf("%s = %v (%s), want %v (%s)", tt.key, tt.value, conf.Origin(tt.key), tt.want, tt.origin)
+		}
+	}
+
+	if _, err := applyEnvToFlags(newConfFlagSet(t), EnvironmentVars{"MANABUILD_TEST": "maybe"}); err == nil {
+		t.Error("expected error for an invalid value of MANABUILD_TEST")
+	}
+}
+
+func TestLoadConfLayers(t *testing.T) {
+	_, projectDir := createRepoFixture(t)
+	confPath := writeConfFile(t, projectDir, `{
+		"targetBinaryName": "app",
+		"manateeLib": "/usr/lib",
+		"runTests": true,
+		"profiles": {"ci": {"runTests": false, "withPcre2": true}}
+	}`)
+	env := EnvironmentVars{
+		"MANABUILD_MANATEE_SRC": "/usr/local/src/manatee-open-2.225.8",
+		"MANABUILD_WITH_PCRE2":  "false",
+	}
+	fset := newConfFlagSet(t, "-manatee-lib", "/opt/manatee/lib")
+	fromEnv, err := applyEnvToFlags(fset, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := loadConfLayers(projectDir, "ci", env, fset, fromEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key    string
+		origin string
+	}{
+		{"targetBinaryName", "project config " + confPath},
+		{"manateeLib", "flag -manatee-lib"},
+		{"manateeSrc", "environment MANABUILD_MANATEE_SRC"},
+		{"runTests", "profile ci"},
+		{"withPcre2", "environment MANABUILD_WITH_PCRE2"},
+		{"stripSymbols", OriginDefault},
+	}
+	for _, tt := range tests {
+		if origin := conf.Origin(tt.key); origin != tt.origin {
+			t.Errorf("unexpected origin of %s: %s, want %s", tt.key, origin, tt.origin)
+		}
+	}
+	if conf.ManateeLib != "/opt/manatee/lib" || conf.RunTests || conf.WithPCRE2 {
+		t.Errorf("unexpected configuration %+v", conf)
+	}
+
+	env["MANABUILD_LOCK_TIMEOUT"] = "-1m"
+	if _, err := loadConfLayers(projectDir, "", env, newConfFlagSet(t), map[string]string{}); err == nil {
+		t.Error("expected error for an invalid configuration")
+	}
+}
+
+func TestResolveManateeVersion(t *testing.T) {
+	libDir := createLibFixture(t, "testdata/libmanatee-2.225.8.so")
+	tests := []struct {
+		name         string
+		spec         string
+		detected     Version
+		allowUnknown bool
+		want         Version
+		wantErr      bool
+	}{
+		{"no spec", "", Version{2, 214, 1, ""}, false, Version{2, 214, 1, ""}, false},
+		{"exact", "2.223.6", Version{2, 225, 8, ""}, false, Version{2, 223, 6, ""}, false},
+		{"exact unknown", "2.300.1", Version{}, false, Version{2, 300, 1, ""}, false},
+		{"detected matches", "~2.214", Version{2, 214, 1, ""}, false, Version{2, 214, 1, ""}, false},
+		{"detected unknown", ">=2.208", Version{2, 230, 0, ""}, false, Version{2, 225, 8, ""}, false},
+		{"detected unknown allowed", ">=2.208", Version{2, 230, 0, ""}, true, Version{2, 230, 0, ""}, false},
+		{"installed matches", ">=2.208", Version{2, 167, 8, ""}, false, Version{2, 225, 8, ""}, false},
+		{"known matches", "2.223.x", Version{2, 167, 8, ""}, false, Version{2, 223, 6, ""}, false},
+		{"highest known", "<2.208", Version{}, false, Version{2, 167, 10, ""}, false},
+		{"nothing matches", ">=3.0", Version{2, 225, 8, ""}, false, Version{}, true},
+		{"invalid spec", ">=2.foo", Version{}, false, Version{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveManateeVersion(tt.spec, tt.detected, libDir, tt.allowUnknown)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error value %v", err)
+			}
+			if !got.Eq(tt.want) {
+				t.Errorf("got %s, want %s", got.Full(), tt.want.Full())
+			}
+		})
+	}
+}
+
+func TestVerifyProvidedManatee(t *testing.T) {
+	seq := NewOperationSequence(time.UTC)
+	upstream := Version{2, 225, 8, ""}
+	cnc := Version{2, 225, 8, "cnc"}
+	tests := []struct {
+		name      string
+		isSrcTree bool
+		found     Version
+		detectErr error
+		required  Version
+		force     bool
+		wantErr   bool
+	}{
+		{"match", false, upstream, nil, upstream, false, false},
+		{"different version", false, Version{2, 223, 6, ""}, nil, upstream, false, true},
+		{"different version forced", false, Version{2, 223, 6, ""}, nil, upstream, true, false},
+		{"library variant differs", false, upstream, nil, cnc, false, true},
+		{"library variant differs forced", false, upstream, nil, cnc, true, false},
+		{"variant-less source tree", true, upstream, nil, cnc, false, false},
+		{"source tree of a different variant", true, cnc, nil, upstream, false, true},
+		{"source tree of a different version", true, Version{2, 223, 6, ""}, nil, cnc, false, true},
+		{"undetectable version", true, Version{}, ErrNoVersionMarker, upstream, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyProvidedManatee(
+				seq, "test component", tt.isSrcTree, tt.found, tt.detectErr, tt.required, tt.force)
+			if tt.wantErr && !errors.Is(err, ErrVersionMismatch) {
+				t.Errorf("expected ErrVersionMismatch, got %v", err)
+
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyProvidedSrcTree(t *testing.T) {
+	seq := NewOperationSequence(time.UTC)
+	srcDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(srcDir, "configure"), []byte("PACKAGE_VERSION='2.225.8'\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, detectErr := srcManateeVersion(srcDir)
+	if err := verifyProvidedManatee(seq, srcDir, true, found, detectErr, Version{2, 225, 8, "cnc"}, false); err != nil {
+		t.Errorf("variant-less sources should match any variant, got %v", err)
+	}
+	err = verifyProvidedManatee(seq, srcDir, true, found, detectErr, Version{2, 223, 6, ""}, false)
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("expected ErrVersionMismatch, got %v", err)
+	}
+}
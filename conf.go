@@ -19,53 +19,556 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/czcorpus/cnc-gokit/fs"
 )
 
 const (
-	confFileName = ".manabuild.json"
-)
+	confFileName     = ".manabuild.json"
+	userConfFileName = "config.json"
+	envVarPrefix     = "MANABUILD_"
 
-var (
-	ErrNoConfig = errors.New("config not found")
+	OriginDefault    = "default"
+	OriginAutodetect = "autodetection"
 )
 
+// BuildTarget describes a single binary built out of a project.
+type BuildTarget struct {
+	BinaryName string `json:"binaryName" desc:"Name of the resulting binary"`
+
+	// CmdDir is a subdirectory of `cmd` containing the respective
+	// main package. If empty, the project root is built.
+	CmdDir string `json:"cmdDir" desc:"A subdirectory of cmd containing the main package"`
+
+	// LdFlags are appended to the linker flags generated by manabuild
+	LdFlags string `json:"ldFlags,omitempty" desc:"Additional linker flags"`
+}
+
+// BuildProfile overrides selected build settings. Only the values
+// specified in the profile are applied.
+type BuildProfile struct {
+	StripSymbols *bool   `json:"stripSymbols" desc:"Apply the -w -s linker flags"`
+	RunTests     *bool   `json:"runTests" desc:"Run unit tests before build"`
+	WithPCRE2    *bool   `json:"withPcre2" desc:"Configure Manatee with PCRE2"`
+	OutputDir    *string `json:"outputDir" desc:"Output directory relative to the project path"`
+}
+
 // Conf represents a .manabuild.json configuration file
 // providing a way how to configure a building process.
+// The configuration may be merged from multiple files
+// (see LoadConfig). All the values can be overridden
+// by respective command line flags/arguments.
 type Conf struct {
-	isLoaded         bool
-	srcPath          string
-	TargetBinaryName string `json:"targetBinaryName"`
+	srcPaths []string
+
+	// origins maps config keys to descriptions of where
+	// their current values come from
+	origins map[string]string
+
+	// Schema allows for referring to the JSON schema of the file
+	// (see ConfJSONSchema) to get editor support. It is ignored
+	// otherwise.
+	Schema string `json:"$schema,omitempty" desc:"JSON schema of the file (ignored by manabuild)"`
+
+	// ManateeVersion specifies required Manatee version or a version
+	// constraint (the same as the second positional argument)
+	ManateeVersion string `json:"manateeVersion" desc:"Required Manatee version or version constraint (e.g. 2.225.8, >=2.208, ~2.225, 2.223.x)"`
+
+	// ManateeSrc is a location of Manatee source files.
+	// A relative path is resolved against the config file location.
+	ManateeSrc string `json:"manateeSrc" desc:"Location of Manatee source files"`
+
+	// ManateeLib is a directory containing libmanatee.so.
+	// A relative path is resolved against the config file location.
+	ManateeLib string `json:"manateeLib" desc:"Directory containing libmanatee.so"`
+
+	TargetBinaryName string `json:"targetBinaryName" desc:"Name of the resulting binary"`
+
+	// CmdDir is a subdirectory of `cmd` to be used for build
+	CmdDir string `json:"cmdDir" desc:"A subdirectory of cmd containing the main package"`
+
+	WithPCRE2 bool `json:"withPcre2" desc:"Configure Manatee with PCRE2"`
+
+	RunTests bool `json:"runTests" desc:"Run unit tests before build"`
+
+	// Targets allows for building multiple binaries within a single run.
+	// If empty, a single target defined by TargetBinaryName and CmdDir
+	// is used.
+	Targets []BuildTarget `json:"targets" desc:"Multiple binaries to be built within a single run"`
+
+	// StripSymbols specifies whether the `-w -s` linker flags
+	// (i.e. no symbol table and no DWARF) should be applied.
+	StripSymbols bool `json:"stripSymbols" desc:"Apply the -w -s linker flags"`
+
+	// OutputDir is a directory (relative to the project path)
+	// where the built binaries are written.
+	OutputDir string `json:"outputDir" desc:"Output directory relative to the project path"`
+
+	// Profiles contains named sets of settings (e.g. "dev", "release")
+	// selectable via the `-profile` flag.
+	Profiles map[string]BuildProfile `json:"profiles" desc:"Named build profiles selectable via -profile"`
+
+	// AllowUnknownVersion enables building against Manatee versions
+	// not listed among the known ones (using the closest recipe)
+	AllowUnknownVersion bool `json:"allowUnknownVersion" desc:"Allow building against Manatee versions not listed among the supported ones"`
+
+	// CacheDir is a directory where Manatee source archives are
+	// downloaded and unpacked. If empty, $XDG_CACHE_HOME/manabuild
+	// is used. A relative path is resolved against the config file
+	// location.
+	CacheDir string `json:"cacheDir" desc:"Directory for downloaded and unpacked Manatee sources (default: $XDG_CACHE_HOME/manabuild)"`
+
+	// Mirrors is an ordered list of locations of Manatee source archives
+	// replacing the default ones. Both URL templates (http, https, file)
+	// and local paths (files or directories containing the archives)
+	// are supported, with {version} and {variant} placeholders.
+	// A relative path is resolved against the config file location.
+	Mirrors []string `json:"mirrors" desc:"Ordered list of source archive locations (URL templates or local paths, e.g. https://mirror/manatee-open-{version}{variant}.tar.gz)"`
+
+	// DownloadTimeout limits a single attempt to download a source
+	// archive (Go duration syntax, e.g. 90s, 10m)
+	DownloadTimeout string `json:"downloadTimeout" desc:"Time limit of a single download attempt (e.g. 90s, 10m)"`
+
+	// DownloadRetries specifies how many times a failed download
+	// is retried (with an exponential backoff)
+	DownloadRetries int `json:"downloadRetries" desc:"Number of retries of a failed download"`
+
+	// LockTimeout limits waiting for a Manatee source tree locked
+	// by another manabuild process (Go duration syntax, 0 means
+	// no limit)
+	LockTimeout string `json:"lockTimeout" desc:"Time limit of waiting for Manatee sources locked by another process (e.g. 30m, 0 = no limit)"`
+
+	// Checksums contains SHA-256 checksums of Manatee source archives
+	// (version => hex digest) in addition to the built-in ones
+	Checksums map[string]string `json:"checksums" desc:"SHA-256 checksums of Manatee source archives (version => hex digest)"`
+
+	// RequireChecksums makes a missing checksum of a downloaded
+	// archive a failure also for versions not listed among the known
+	// ones (by default, just a warning is printed for them)
+	RequireChecksums bool `json:"requireChecksums" desc:"Fail in case there is no known checksum of a source archive of an unknown version"`
+
+	// RecipesFile is a JSON file with build recipes overriding
+	// the built-in ones. A relative path is resolved against
+	// the config file location.
+	RecipesFile string `json:"recipesFile" desc:"JSON file with build recipes overriding the built-in ones"`
+
+	// Recipes override both the built-in recipes and the ones
+	// from RecipesFile (see LoadRecipes).
+	Recipes []BuildRecipe `json:"recipes" desc:"Build recipes overriding the built-in ones"`
+}
+
+// NewConf creates a configuration with default values
+// (i.e. the values used in case there is no config file).
+func NewConf() *Conf {
+	return &Conf{
+		StripSymbols:    true,
+		DownloadTimeout: DefaultDownloadTimeout.String(),
+		DownloadRetries: DefaultDownloadRetries,
+		LockTimeout:     DefaultSrcLockTimeout.String(),
+		origins:         make(map[string]string),
+	}
+}
+
+// SetOrigin records where a value of a config key comes from
+func (conf *Conf) SetOrigin(key, origin string) {
+	conf.origins[key] = origin
+}
+
+// Origin returns a description of where a value of a config
+// key comes from.
+func (conf *Conf) Origin(key string) string {
+	if v, ok := conf.origins[key]; ok {
+		return v
+	}
+	return OriginDefault
 }
 
 func (conf *Conf) IsLoaded() bool {
-	return conf.isLoaded
+	return len(conf.srcPaths) > 0
+}
+
+// SrcPaths returns paths of all the applied config files
+// in the order they were applied.
+func (conf *Conf) SrcPaths() []string {
+	return conf.srcPaths
+}
+
+// ApplyProfile overrides the configuration by values
+// specified in a profile of the provided name.
+func (conf *Conf) ApplyProfile(name string) error {
+	prof, ok := conf.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown build profile %s", name)
+	}
+	origin := "profile " + name
+	if prof.StripSymbols != nil {
+		conf.StripSymbols = *prof.StripSymbols
+		conf.SetOrigin("stripSymbols", origin)
+	}
+	if prof.RunTests != nil {
+		conf.RunTests = *prof.RunTests
+		conf.SetOrigin("runTests", origin)
+	}
+	if prof.WithPCRE2 != nil {
+		conf.WithPCRE2 = *prof.WithPCRE2
+		conf.SetOrigin("withPcre2", origin)
+	}
+	if prof.OutputDir != nil {
+		conf.OutputDir = *prof.OutputDir
+		conf.SetOrigin("outputDir", origin)
+	}
+	return nil
 }
 
-func (conf *Conf) SrcPath() string {
-	return conf.srcPath
+// ApplyProfileAndEnv applies MANABUILD_* environment variables and
+// a build profile (if specified). Profiles defined via MANABUILD_PROFILES
+// can be selected too, while the variables still take precedence over
+// values set by the profile.
+func (conf *Conf) ApplyProfileAndEnv(profile string, env EnvironmentVars) error {
+	if err := conf.ApplyEnv(env); err != nil {
+		return fmt.Errorf("failed to apply environment variables: %w", err)
+	}
+	if profile == "" {
+		return nil
+	}
+	if err := conf.ApplyProfile(profile); err != nil {
+		return fmt.Errorf("failed to apply build profile: %w", err)
+	}
+	return conf.ApplyEnv(env)
 }
 
-func TryConfig(workingDir string, conf *Conf) error {
-	path := filepath.Join(workingDir, confFileName)
-	if !fs.PathExists(path) {
-		return ErrNoConfig
+// BuildTargets returns targets to be built. In case `selected` is
+// non-empty, only the targets with matching binary names are returned.
+func (conf *Conf) BuildTargets(selected []string) ([]BuildTarget, error) {
+	targets := conf.Targets
+	if len(targets) == 0 {
+		if conf.TargetBinaryName == "" {
+			return []BuildTarget{}, nil
+		}
+		targets = []BuildTarget{{BinaryName: conf.TargetBinaryName, CmdDir: conf.CmdDir}}
+	}
+	if len(selected) == 0 {
+		return targets, nil
 	}
-	conf.srcPath = path
+	ans := make([]BuildTarget, 0, len(selected))
+	for _, name := range selected {
+		var found bool
+		for _, t := range targets {
+			if t.BinaryName == name {
+				ans = append(ans, t)
+				found = true
+				break
+			}
+		}
+		if !found {
+			avail := make([]string, len(targets))
+			for i, t := range targets {
+				avail[i] = t.BinaryName
+			}
+			return []BuildTarget{}, fmt.Errorf(
+				"unknown target %s (available: %s)", name, strings.Join(avail, ", "))
+		}
+	}
+	return ans, nil
+}
+
+// findProjectConfigs returns paths of all the project config files
+// found while walking from the project directory up to the root
+// of the respective repository (i.e. a directory containing `.git`).
+// In case the project is not within a repository, only the project
+// directory is searched. The paths are ordered from the farthest
+// to the nearest one (i.e. in the order they should be applied).
+func findProjectConfigs(projectPath string) ([]string, error) {
+	dir, err := filepath.Abs(projectPath)
+	if err != nil {
+		return []string{}, fmt.Errorf("failed to determine project path: %w", err)
+	}
+	candidates := make([]string, 0, 5)
+	for {
+		candidates = append(candidates, filepath.Join(dir, confFileName))
+		if fs.PathExists(filepath.Join(dir, ".git")) {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			// no repository found => use just the project directory
+			candidates = candidates[:1]
+			break
+		}
+		dir = parent
+	}
+	ans := make([]string, 0, len(candidates))
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if fs.PathExists(candidates[i]) {
+			ans = append(ans, candidates[i])
+		}
+	}
+	return ans, nil
+}
+
+// userConfigPath returns a path of a user-level config file
+// (typically $XDG_CONFIG_HOME/manabuild/config.json) or an empty
+// string if the path cannot be determined.
+func userConfigPath() string {
+	confDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(confDir, "manabuild", userConfFileName)
+}
+
+// mergeFile applies values found in a config file to the configuration.
+// Only the keys present in the file are overwritten. Values are replaced
+// as a whole (e.g. targets of a file are never combined with the ones
+// defined in previous layers).
+func (conf *Conf) mergeFile(path, originType string) error {
 	rawData, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return fmt.Errorf("failed to load config %s: %w", path, err)
+	}
+	var layer Conf
+	if err := decodeStrict(rawData, &layer); err != nil {
+		return fmt.Errorf("failed to load config %s: %w", path, err)
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(rawData, &keys); err != nil {
+		return fmt.Errorf("failed to load config %s: %w", path, err)
+	}
+	confDir := filepath.Dir(path)
+	layer.ManateeSrc = resolveConfPath(confDir, layer.ManateeSrc)
+	layer.ManateeLib = resolveConfPath(confDir, layer.ManateeLib)
+	layer.CacheDir = resolveConfPath(confDir, layer.CacheDir)
+	layer.RecipesFile = resolveConfPath(confDir, layer.RecipesFile)
+	for i, m := range layer.Mirrors {
+		layer.Mirrors[i] = resolveMirrorConfPath(confDir, m)
+	}
+	dst := reflect.ValueOf(conf).Elem()
+	src := reflect.ValueOf(&layer).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		key := strings.Split(dst.Type().Field(i).Tag.Get("json"), ",")[0]
+		if _, ok := keys[key]; ok && key != "" && key != "-" {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	for k := range keys {
+		conf.SetOrigin(k, fmt.Sprintf("%s %s", originType, path))
+	}
+	conf.srcPaths = append(conf.srcPaths, path)
+	return nil
+}
+
+// LoadConfig creates a configuration by merging the following
+// layers (each one overriding the previous ones):
+//
+//  1. default values
+//  2. user config ($XDG_CONFIG_HOME/manabuild/config.json)
+//  3. project configs (.manabuild.json) found between the repository
+//     root and the project directory (the nearest one wins)
+//
+// The caller is expected to apply a selected profile, environment
+// variables (see ApplyEnv) and command line flags (in that order)
+// on top of the returned configuration and validate the result
+// (see Validate).
+func LoadConfig(projectPath string) (*Conf, error) {
+	conf := NewConf()
+	if userPath := userConfigPath(); userPath != "" && fs.PathExists(userPath) {
+		if err := conf.mergeFile(userPath, "user config"); err != nil {
+			return conf, err
+		}
+	}
+	projPaths, err := findProjectConfigs(projectPath)
+	if err != nil {
+		return conf, err
+	}
+	for _, p := range projPaths {
+		if err := conf.mergeFile(p, "project config"); err != nil {
+			return conf, err
+		}
+	}
+	return conf, nil
+}
+
+// decodeStrict decodes JSON data into a config value. Unlike
+// json.Unmarshal, unknown keys are reported as errors.
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return fmt.Errorf(
+				"invalid type of %s: expected %s, found %s", typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after the top-level object")
+	}
+	return nil
+}
+
+// Validate performs semantic checks of the configuration
+// (version strings, targets). All found problems are reported.
+func (conf *Conf) Validate() error {
+	errs := make([]error, 0, 5)
+	if conf.ManateeVersion != "" {
+		if _, err := ParseVersionConstraint(conf.ManateeVersion); err != nil {
+			errs = append(errs, fmt.Errorf("invalid manateeVersion: %w", err))
+		}
+	}
+	names := make(map[string]bool)
+	for i, t := range conf.Targets {
+		if t.BinaryName == "" {
+			errs = append(errs, fmt.Errorf("targets[%d]: missing binaryName", i))
+
+		} else if names[t.BinaryName] {
+			errs = append(errs, fmt.Errorf("targets[%d]: duplicate binaryName %s", i, t.BinaryName))
+		}
+		names[t.BinaryName] = true
+	}
+	if _, err := time.ParseDuration(conf.DownloadTimeout); err != nil {
+		errs = append(errs, fmt.Errorf("invalid downloadTimeout: %w", err))
+	}
+	if conf.DownloadRetries < 0 {
+		errs = append(errs, fmt.Errorf("invalid downloadRetries: %d", conf.DownloadRetries))
+	}
+	if _, err := conf.SrcLockTimeout(); err != nil {
+		errs = append(errs, err)
+	}
+	for i, m := range conf.Mirrors {
+		if _, ok := localMirrorPath(m); !ok && !isRemoteMirror(m) {
+			errs = append(errs, fmt.Errorf("mirrors[%d]: unsupported location %s", i, m))
+		}
+	}
+	if _, err := LoadChecksums(conf); err != nil {
+		errs = append(errs, err)
+	}
+	for i, r := range conf.Recipes {
+		if err := r.init(""); err != nil {
+			errs = append(errs, fmt.Errorf("recipes[%d]: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewDownloader creates a downloader configured
+// according to the download* settings
+func (conf *Conf) NewDownloader() (*Downloader, error) {
+	timeout, err := time.ParseDuration(conf.DownloadTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid downloadTimeout: %w", err)
 	}
-	err = json.Unmarshal(rawData, conf)
+	return NewDownloader(timeout, conf.DownloadRetries), nil
+}
+
+// SrcLockTimeout returns a parsed lockTimeout value
+func (conf *Conf) SrcLockTimeout() (time.Duration, error) {
+	timeout, err := time.ParseDuration(conf.LockTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid lockTimeout: %w", err)
+	}
+	if timeout < 0 {
+		return 0, fmt.Errorf("invalid lockTimeout: %s", conf.LockTimeout)
+	}
+	return timeout, nil
+}
+
+// ValidateConfigFile checks a single config file without
+// merging it with other config layers.
+func ValidateConfigFile(path string) error {
+	rawData, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return err
+	}
+	return validateConfigData(rawData)
+}
+
+// validateConfigData checks contents of a single config file
+func validateConfigData(rawData []byte) error {
+	conf := NewConf()
+	if err := decodeStrict(rawData, conf); err != nil {
+		return err
+	}
+	return conf.Validate()
+}
+
+// EnvVarName returns a name of an environment variable
+// corresponding to a config key or to a command line flag
+// (e.g. manateeLib => MANABUILD_MANATEE_LIB,
+// with-pcre2 => MANABUILD_WITH_PCRE2).
+func EnvVarName(key string) string {
+	var b strings.Builder
+	b.WriteString(envVarPrefix)
+	for i, r := range key {
+		if r == '-' {
+			b.WriteRune('_')
+
+		} else if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+
+		} else {
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// ApplyEnv overrides the configuration by MANABUILD_* environment
+// variables. Scalar values are written as they are (booleans
+// accept the same values as strconv.ParseBool), complex values
+// (targets, profiles) must be encoded as JSON.
+func (conf *Conf) ApplyEnv(env EnvironmentVars) error {
+	val := reflect.ValueOf(conf).Elem()
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Type().Field(i)
+		key := strings.Split(field.Tag.Get("json"), ",")[0]
+		if key == "" || key == "-" || strings.HasPrefix(key, "$") {
+			continue
+		}
+		envName := EnvVarName(key)
+		envVal, ok := env[envName]
+		if !ok {
+			continue
+		}
+		switch field.Type.Kind() {
+		case reflect.String:
+			val.Field(i).SetString(envVal)
+		case reflect.Bool:
+			bv, err := strconv.ParseBool(envVal)
+			if err != nil {
+				return fmt.Errorf("invalid value of %s: %w", envName, err)
+			}
+			val.Field(i).SetBool(bv)
+		default:
+			// a fresh value is used so nothing leaks from the previous layers
+			newVal := reflect.New(field.Type)
+			if err := json.Unmarshal([]byte(envVal), newVal.Interface()); err != nil {
+				return fmt.Errorf("invalid value of %s: %w", envName, err)
+			}
+			val.Field(i).Set(newVal.Elem())
+		}
+		conf.SetOrigin(key, "environment "+envName)
 	}
-	conf.isLoaded = true
 	return nil
 }
+
+func resolveConfPath(confDir, p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(confDir, p)
+}
@@ -0,0 +1,545 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfFile writes a config file into dir (created if needed)
+func writeConfFile(t *testing.T, dir, content string) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, confFileName)
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// createRepoFixture creates a repository root (with .git) and a project
+// directory within it and isolates the test from the user's config
+func createRepoFixture(t *testing.T) (string, string) {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	projectDir := filepath.Join(root, "services", "api")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return root, projectDir
+}
+
+func TestLoadConfigLayersDoNotLeak(t *testing.T) {
+	root, projectDir := createRepoFixture(t)
+	writeConfFile(t, root, `{
+		"targets": [{"binaryName": "a", "cmdDir": "a", "ldFlags": "-X main.leak=1"}],
+		"mirrors": ["mirrors/root", "https://example.org/a-{version}.tar.gz"],
+		"runTests": true
+	}`)
+	writeConfFile(t, projectDir, `{
+		"targets": [{"binaryName": "b"}],
+		"mirrors": ["https://example.org/b-{version}.tar.gz"]
+	}`)
+	conf, err := LoadConfig(projectDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := BuildTarget{BinaryName: "b"}
+	if len(conf.Targets) != 1 || conf.Targets[0] != want {
+		t.Errorf("expected targets %v, got %v", []BuildTarget{want}, conf.Targets)
+	}
+	if len(conf.Mirrors) != 1 || conf.Mirrors[0] != "https://example.org/b-{version}.tar.gz" {
+		t.Errorf("unexpected mirrors %v", conf.Mirrors)
+	}
+	if !conf.RunTests {
+		t.Error("runTests defined in the repository root should be kept")
+	}
+	if origin := conf.Origin("runTests"); origin != "project config "+filepath.Join(root, confFileName) {
+		t.Errorf("unexpected origin of runTests: %s", origin)
+	}
+}
+
+func TestValidateAfterEnvOverrides(t *testing.T) {
+	conf := NewConf()
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("default configuration should be valid, got %v", err)
+	}
+	env := EnvironmentVars{"MANABUILD_DOWNLOAD_RETRIES": "-1"}
+	if err := conf.ApplyEnv(env); err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.Validate(); err == nil {
+		t.Error("expected validation error for a negative downloadRetries set via environment")
+	}
+}
+
+func TestConfigWithSchemaReference(t *testing.T) {
+	p := writeConfFile(t, t.TempDir(), `{"$schema": "./manabuild.schema.json", "targetBinaryName": "app"}`)
+	if err := ValidateConfigFile(p); err != nil {
+		t.Errorf("$schema should be accepted, got %v", err)
+	}
+	props := ConfJSONSchema()["properties"].(map[string]any)
+	if _, ok := props["$schema"]; !ok {
+		t.Error("$schema should be allowed by the generated schema")
+	}
+}
+
+func TestLoadConfigBuildFlags(t *testing.T) {
+	root, projectDir := createRepoFixture(t)
+	p := writeConfFile(t, projectDir, `{
+		"manateeVersion": "2.225.8",
+		"manateeSrc": "../manatee-src",
+		"manateeLib": "/usr/lib",
+		"targetBinaryName": "api",
+		"cmdDir": "api",
+		"withPcre2": true,
+		"runTests": true,
+		"stripSymbols": false,
+		"outputDir": "bin"
+	}`)
+	conf, err := LoadConfig(projectDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.ManateeVersion != "2.225.8" || conf.TargetBinaryName != "api" || conf.CmdDir != "api" ||
+		!conf.WithPCRE2 || !conf.RunTests || conf.StripSymbols || conf.OutputDir != "bin" {
+		t.Errorf("unexpected configuration %+v", conf)
+	}
+	// relative paths are resolved against the config file location
+	if want := filepath.Join(root, "services", "manatee-src"); conf.ManateeSrc != want {
+		t.Errorf("expected manateeSrc %s, got %s", want, conf.ManateeSrc)
+	}
+	if conf.ManateeLib != "/usr/lib" {
+		t.Errorf("absolute manateeLib should be kept, got %s", conf.ManateeLib)
+	}
+	if paths := conf.SrcPaths(); len(paths) != 1 || paths[0] != p {
+		t.Errorf("unexpected config paths %v", paths)
+	}
+	if origin := conf.Origin("outputDir"); origin != "project config "+p {
+		t.Errorf("unexpected origin of outputDir: %s", origin)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	_, projectDir := createRepoFixture(t)
+	conf, err := LoadConfig(projectDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.IsLoaded() {
+		t.Error("no config file should be loaded")
+	}
+	if !conf.StripSymbols || conf.RunTests || conf.WithPCRE2 || conf.OutputDir != "" {
+		t.Errorf("unexpected default values %+v", conf)
+	}
+	if origin := conf.Origin("stripSymbols"); origin != OriginDefault {
+		t.Errorf("unexpected origin of a default value: %s", origin)
+	}
+}
+
+func TestBuildTargets(t *testing.T) {
+	multi := &Conf{Targets: []BuildTarget{
+		{BinaryName: "server", CmdDir: "server"},
+		{BinaryName: "worker", CmdDir: "worker", LdFlags: "-X main.mode=worker"},
+	}}
+	single := &Conf{TargetBinaryName: "app", CmdDir: "app"}
+	tests := []struct {
+		name     string
+		conf     *Conf
+		selected []string
+		want     []string
+		wantErr  bool
+	}{
+		{"all targets", multi, []string{}, []string{"server", "worker"}, false},
+		{"selected targets", multi, []string{"worker"}, []string{"worker"}, false},
+		{"selection order", multi, []string{"worker", "server"}, []string{"worker", "server"}, false},
+		{"unknown target", multi, []string{"server", "cron"}, []string{}, true},
+		{"single target", single, []string{}, []string{"app"}, false},
+		{"single target selected", single, []string{"app"}, []string{"app"}, false},
+		{"single target unknown", single, []string{"server"}, []string{}, true},
+		{"no target", &Conf{}, []string{}, []string{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, err := tt.conf.BuildTargets(tt.selected)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error value %v", err)
+			}
+			names := make([]string, len(targets))
+			for i, target := range targets {
+				names[i] = target.BinaryName
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got targets %v, want %v", names, tt.want)
+			}
+		})
+	}
+	targets, _ := single.BuildTargets([]string{})
+	if targets[0].CmdDir != "app" {
+		t.Errorf("single target should use cmdDir, got %v", targets[0])
+	}
+}
+
+func TestBuildTargetsUnknownListsAvailable(t *testing.T) {
+	conf := &Conf{Targets: []BuildTarget{{BinaryName: "server"}, {BinaryName: "worker"}}}
+	_, err := conf.BuildTargets([]string{"cron"})
+	if err == nil || !strings.Contains(err.Error(), "server, worker") {
+		t.Errorf("expected error listing available targets, got %v", err)
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	_, projectDir := createRepoFixture(t)
+	writeConfFile(t, projectDir, `{
+		"targetBinaryName": "app",
+		"runTests": true,
+		"outputDir": "bin",
+		"profiles": {
+			"release": {"stripSymbols": true, "runTests": false, "outputDir": "dist"},
+			"dev": {"stripSymbols": false}
+		}
+	}`)
+	conf, err := LoadConfig(projectDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.ApplyProfile("dev"); err != nil {
+		t.Fatal(err)
+	}
+	if conf.StripSymbols || !conf.RunTests || conf.OutputDir != "bin" {
+		t.Errorf("only values defined in the profile should be applied, got %+v", conf)
+	}
+	if origin := conf.Origin("stripSymbols"); origin != "profile dev" {
+		t.Errorf("unexpected origin of stripSymbols: %s", origin)
+	}
+	if origin := conf.Origin("runTests"); origin == "profile dev" {
+		t.Error("origin of values not defined in the profile should be kept")
+	}
+	if err := conf.ApplyProfile("release"); err != nil {
+		t.Fatal(err)
+	}
+	if !conf.StripSymbols || conf.RunTests || conf.OutputDir != "dist" || conf.WithPCRE2 {
+		t.Errorf("unexpected configuration after release profile %+v", conf)
+	}
+	if err := conf.ApplyProfile("ci"); err == nil {
+		t.Error("expected error for an unknown profile")
+	}
+}
+
+func TestApplyProfileAndEnv(t *testing.T) {
+	_, projectDir := createRepoFixture(t)
+	writeConfFile(t, projectDir, `{"targetBinaryName": "app", "outputDir": "bin"}`)
+	env := EnvironmentVars{
+		"MANABUILD_PROFILES":      `{"ci": {"runTests": true, "stripSymbols": false, "outputDir": "dist"}}`,
+		"MANABUILD_STRIP_SYMBOLS": "true",
+	}
+	conf, err := LoadConfig(projectDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.ApplyProfileAndEnv("ci", env); err != nil {
+		t.Fatalf("a profile defined via environment should be selectable: %v", err)
+	}
+	if !conf.RunTests || conf.OutputDir != "dist" {
+		t.Errorf("profile values should be applied, got %+v", conf)
+	}
+	if origin := conf.Origin("runTests"); origin != "profile ci" {
+		t.Errorf("unexpected origin of runTests: %s", origin)
+	}
+	if !conf.StripSymbols || conf.Origin("stripSymbols") != "environment MANABUILD_STRIP_SYMBOLS" {
+		t.Errorf("environment should take precedence over the profile, got %t (%s)",
+			conf.StripSymbols, conf.Origin("stripSymbols"))
+	}
+
+	conf, err = LoadConfig(projectDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.ApplyProfileAndEnv("release", env); err == nil {
+		t.Error("expected error for an unknown profile")
+	}
+	conf, err = LoadConfig(projectDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.ApplyProfileAndEnv("", env); err != nil || conf.RunTests || conf.OutputDir != "bin" {
+		t.Errorf("profiles should not be applied unless selected, got %+v (%v)", conf, err)
+	}
+}
+
+func TestFindProjectConfigs(t *testing.T) {
+	root, projectDir := createRepoFixture(t)
+	rootConf := writeConfFile(t, root, `{}`)
+	servicesConf := writeConfFile(t, filepath.Dir(projectDir), `{}`)
+	projectConf := writeConfFile(t, projectDir, `{}`)
+	// configs above the repository root are ignored
+	writeConfFile(t, filepath.Dir(root), `{}`)
+
+	paths, err := findProjectConfigs(projectDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{rootConf, servicesConf, projectConf}
+	if strings.Join(paths, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", paths, want)
+	}
+
+	os.Remove(servicesConf)
+	paths, _ = findProjectConfigs(projectDir)
+	if strings.Join(paths, ",") != rootConf+","+projectConf {
+		t.Errorf("missing configs should be skipped, got %v", paths)
+	}
+}
+
+func TestFindProjectConfigsOutsideRepository(t *testing.T) {
+	parent := t.TempDir()
+	writeConfFile(t, parent, `{}`)
+	projectDir := filepath.Join(parent, "app")
+	projectConf := writeConfFile(t, projectDir, `{}`)
+	paths, err := findProjectConfigs(projectDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || paths[0] != projectConf {
+		t.Errorf("only the project config should be found outside a repository, got %v", paths)
+	}
+}
+
+func TestLoadConfigLayerPrecedence(t *testing.T) {
+	root, projectDir := createRepoFixture(t)
+	userDir := filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "manabuild")
+	if err := os.MkdirAll(userDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	userConf := filepath.Join(userDir, userConfFileName)
+	err := os.WriteFile(userConf, []byte(`{"manateeLib": "/opt/lib", "outputDir": "user", "withPcre2": true}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootConf := writeConfFile(t, root, `{"outputDir": "root", "runTests": true}`)
+	projectConf := writeConfFile(t, projectDir, `{"runTests": false}`)
+
+	conf, err := LoadConfig(projectDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key    string
+		ok     bool
+		origin string
+	}{
+		{"manateeLib", conf.ManateeLib == "/opt/lib", "user config " + userConf},
+		{"withPcre2", conf.WithPCRE2, "user config " + userConf},
+		{"outputDir", conf.OutputDir == "root", "project config " + rootConf},
+		{"runTests", !conf.RunTests, "project config " + projectConf},
+		{"stripSymbols", conf.StripSymbols, OriginDefault},
+	}
+	for _, tt := range tests {
+		if !tt.ok {
+			t.Errorf("unexpected value of %s", tt.key)
+		}
+		if origin := conf.Origin(tt.key); origin != tt.origin {
+			t.Errorf("unexpected origin of %s: %s, want %s", tt.key, origin, tt.origin)
+		}
+	}
+	if paths := conf.SrcPaths(); strings.Join(paths, ",") != strings.Join([]string{userConf, rootConf, projectConf}, ",") {
+		t.Errorf("unexpected order of applied configs %v", paths)
+	}
+}
+
+func TestEnvVarName(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"manateeLib", "MANABUILD_MANATEE_LIB"},
+		{"withPcre2", "MANABUILD_WITH_PCRE2"},
+		{"targetBinaryName", "MANABUILD_TARGET_BINARY_NAME"},
+		{"targets", "MANABUILD_TARGETS"},
+		{"manatee-lib", "MANABUILD_MANATEE_LIB"},
+		{"with-pcre2", "MANABUILD_WITH_PCRE2"},
+		{"test", "MANABUILD_TEST"},
+	}
+	for _, tt := range tests {
+		if got := EnvVarName(tt.key); got != tt.want {
+			t.Errorf("EnvVarName(%s) = %s, want %s", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	conf := NewConf()
+	conf.Targets = []BuildTarget{{BinaryName: "a", CmdDir: "a", LdFlags: "-X main.leak=1"}}
+	env := EnvironmentVars{
+		"MANABUILD_MANATEE_LIB":      "/opt/manatee/lib",
+		"MANABUILD_STRIP_SYMBOLS":    "0",
+		"MANABUILD_WITH_PCRE2":       "true",
+		"MANABUILD_DOWNLOAD_RETRIES": "5",
+		"MANABUILD_TARGETS":          `[{"binaryName": "b"}]`,
+		"MANABUILD_PROFILES":         `{"ci": {"runTests": true}}`,
+		"MANABUILD_UNRELATED":        "x",
+		"MANABUILD_$SCHEMA":          "x",
+		"PATH":                       "/usr/bin",
+	}
+	if err := conf.ApplyEnv(env); err != nil {
+		t.Fatal(err)
+	}
+	if conf.ManateeLib != "/opt/manatee/lib" || conf.StripSymbols || !conf.WithPCRE2 || conf.DownloadRetries != 5 {
+		t.Errorf("unexpected configuration %+v", conf)
+	}
+	if len(conf.Targets) != 1 || conf.Targets[0] != (BuildTarget{BinaryName: "b"}) {
+		t.Errorf("targets should be replaced as a whole, got %v", conf.Targets)
+	}
+	if p, ok := conf.Profiles["ci"]; !ok || p.RunTests == nil || !*p.RunTests {
+		t.Errorf("unexpected profiles %v", conf.Profiles)
+	}
+	if conf.Schema != "" {
+		t.Error("$schema should not be settable via environment")
+	}
+	if origin := conf.Origin("manateeLib"); origin != "environment MANABUILD_MANATEE_LIB" {
+		t.Errorf("unexpected origin of manateeLib: %s", origin)
+	}
+	if origin := conf.Origin("runTests"); origin != OriginDefault {
+		t.Errorf("unexpected origin of runTests: %s", origin)
+	}
+}
+
+func TestApplyEnvInvalidValues(t *testing.T) {
+	tests := []EnvironmentVars{
+		{"MANABUILD_RUN_TESTS": "maybe"},
+		{"MANABUILD_TARGETS": "server"},
+		{"MANABUILD_DOWNLOAD_RETRIES": "many"},
+	}
+	for _, env := range tests {
+		if err := NewConf().ApplyEnv(env); err == nil {
+			t.Errorf("expected error for %v", env)
+		}
+	}
+}
+
+func TestDecodeStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"valid", `{"manateeVersion": "2.225.8", "targets": [{"binaryName": "mquery"}]}`, ""},
+		{"unknown key", `{"manateeVersoin": "2.225.8"}`, `unknown field "manateeVersoin"`},
+		{"unknown nested key", `{"targets": [{"name": "mquery"}]}`, `unknown field "name"`},
+		{"invalid type", `{"runTests": "yes"}`, "invalid type of runTests: expected bool, found string"},
+		{"trailing data", `{"runTests": true} {"stripSymbols": false}`, "unexpected data after the top-level object"},
+		{"malformed", `{"runTests": true`, "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := decodeStrict([]byte(tt.data), NewConf())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(conf *Conf)
+		wantErr []string
+	}{
+		{"defaults", func(conf *Conf) {}, nil},
+		{"version constraint", func(conf *Conf) { conf.ManateeVersion = ">=2.223, <2.226" }, nil},
+		{"invalid version", func(conf *Conf) { conf.ManateeVersion = "2.225.8.1" }, []string{"invalid manateeVersion"}},
+		{
+			"targets",
+			func(conf *Conf) {
+				conf.Targets = []BuildTarget{{BinaryName: "a"}, {CmdDir: "cmd/b"}, {BinaryName: "a"}}
+			},
+			[]string{"targets[1]: missing binaryName", "targets[2]: duplicate binaryName a"},
+		},
+		{"download timeout", func(conf *Conf) { conf.DownloadTimeout = "5" }, []string{"invalid downloadTimeout"}},
+		{"download retries", func(conf *Conf) { conf.DownloadRetries = -1 }, []string{"invalid downloadRetries: -1"}},
+		{"lock timeout", func(conf *Conf) { conf.LockTimeout = "-1m" }, []string{"invalid lockTimeout: -1m"}},
+		{
+			"mirrors",
+			func(conf *Conf) { conf.Mirrors = []string{"https://mirror/{version}.tar.gz", "ftp://mirror"} },
+			[]string{"mirrors[1]: unsupported location ftp://mirror"},
+		},
+		{"checksums", func(conf *Conf) { conf.Checksums = map[string]string{"2.225.8": "abc"} }, []string{"2.225.8"}},
+		{
+			"multiple problems",
+			func(conf *Conf) {
+				conf.ManateeVersion = "latest"
+				conf.DownloadTimeout = "never"
+				conf.LockTimeout = "forever"
+			},
+			[]string{"invalid manateeVersion", "invalid downloadTimeout", "invalid lockTimeout"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := NewConf()
+			tt.modify(conf)
+			err := conf.Validate()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected errors %v", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q should mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	valid := writeConfFile(t, filepath.Join(dir, "valid"), `{"manateeVersion": "~2.225", "lockTimeout": "10m"}`)
+	if err := ValidateConfigFile(valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	invalid := writeConfFile(t, filepath.Join(dir, "invalid"), `{"manateeVersion": "2.225.8.1", "downloadRetries": -2}`)
+	if err := ValidateConfigFile(invalid); err == nil ||
+		!strings.Contains(err.Error(), "invalid manateeVersion") || !strings.Contains(err.Error(), "invalid downloadRetries") {
+		t.Errorf("expected both problems to be reported, got %v", err)
+	}
+	if err := ValidateConfigFile(filepath.Join(dir, "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("expected not-exist error, got %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/czcorpus/cnc-gokit/fs"
+	"github.com/fatih/color"
+)
+
+// effectiveValue is a resolved configuration value along
+// with a description of where it comes from
+type effectiveValue struct {
+	key    string
+	value  string
+	origin string
+}
+
+// resolveEffectiveConf evaluates the configuration the same way
+// a build does (incl. autodetection of missing values) but without
+// downloading or building anything.
+func resolveEffectiveConf(conf *Conf) []effectiveValue {
+	ans := make([]effectiveValue, 0, 12)
+	var ver Version
+	var err error
+	verOrigin := conf.Origin("manateeVersion")
+	detected, detectErr := AutodetectManateeVersion(conf.ManateeLib, KnownVersions)
+	if conf.ManateeVersion != "" {
+		ver, err = resolveManateeVersion(conf.ManateeVersion, detected, conf.ManateeLib, conf.AllowUnknownVersion)
+		if constr, _ := ParseVersionConstraint(conf.ManateeVersion); err == nil {
+			if _, isExact := constr.ExactVersion(); !isExact {
+				verOrigin = fmt.Sprintf("%s (constraint %s)", verOrigin, constr)
+			}
+		}
+
+	} else {
+		ver, err = detected, detectErr
+		verOrigin = OriginAutodetect
+	}
+	if err != nil {
+		ans = append(ans, effectiveValue{"manateeVersion", "<unresolved: " + err.Error() + ">", verOrigin})
+
+	} else if ver.IsZero() {
+		ans = append(ans, effectiveValue{"manateeVersion", "<not found>", verOrigin})
+
+	} else {
+		ans = append(ans, effectiveValue{"manateeVersion", ver.Full(), verOrigin})
+	}
+
+	cacheDir, cacheErr := conf.CacheRoot()
+	cacheVal := effectiveValue{"cacheDir", cacheDir, conf.Origin("cacheDir")}
+	if cacheErr != nil {
+		cacheVal.value = "<unresolved: " + cacheErr.Error() + ">"
+	}
+	ans = append(ans, cacheVal)
+
+	srcVal := effectiveValue{"manateeSrc", conf.ManateeSrc, conf.Origin("manateeSrc")}
+	if conf.ManateeSrc == "" {
+		if ver.IsZero() || cacheErr != nil {
+			srcVal.value = "<unresolved>"
+
+		} else {
+			srcVal.value = manateeSrcDir(cacheDir, ver)
+		}
+		srcVal.origin = "download location"
+	}
+	ans = append(ans, srcVal)
+
+	if conf.ManateeSrc == "" && !ver.IsZero() {
+		mirrorsVal := effectiveValue{"mirrors", "", conf.Origin("mirrors")}
+		if len(conf.Mirrors) == 0 {
+			mirrorsVal.origin = "variant default"
+		}
+		expanded := make([]string, 0, len(conf.Mirrors))
+		for _, m := range mirrorsFor(ver, conf.Mirrors) {
+			expanded = append(expanded, expandMirrorTemplate(m, ver))
+		}
+		mirrorsVal.value = strings.Join(expanded, ", ")
+		if mirrorsVal.value == "" {
+			mirrorsVal.value = "<none>"
+		}
+		ans = append(ans, mirrorsVal)
+
+		sumVal := effectiveValue{key: "archiveChecksum"}
+		if checksums, err := LoadChecksums(conf); err != nil {
+			sumVal.value = "<unresolved: " + err.Error() + ">"
+
+		} else if sum, source, ok := checksums.Lookup(ver); ok {
+			sumVal.value = sum
+			sumVal.origin = source
+
+		} else {
+			sumVal.value = "<unknown>"
+		}
+		ans = append(ans, sumVal)
+	}
+
+	libVal := effectiveValue{"manateeLib", conf.ManateeLib, conf.Origin("manateeLib")}
+	if conf.ManateeLib == "" {
+		var rejected []rejectedManateeLib
+		libVal.value, rejected = findManatee(ver)
+		if libVal.value == "" {
+			libVal.value = "<not found>"
+		}
+		libVal.origin = OriginAutodetect
+		if len(rejected) > 0 {
+			skipped := make([]string, len(rejected))
+			for i, r := range rejected {
+				skipped[i] = fmt.Sprintf("%s: %s", r.Dir, r.Reason)
+			}
+			libVal.origin = fmt.Sprintf("%s (skipped %s)", OriginAutodetect, strings.Join(skipped, "; "))
+		}
+	}
+	ans = append(ans, libVal)
+
+	targets, _ := conf.BuildTargets([]string{})
+	tNames := make([]string, len(targets))
+	tDirs := make([]string, len(targets))
+	for i, t := range targets {
+		tNames[i] = t.BinaryName
+		tDirs[i] = t.CmdDir
+		if tDirs[i] == "" {
+			tDirs[i] = "."
+		}
+	}
+	if len(conf.Targets) > 0 {
+		ans = append(ans, effectiveValue{"targets", strings.Join(tNames, ", "), conf.Origin("targets")})
+		ans = append(ans, effectiveValue{"cmdDir", strings.Join(tDirs, ", "), conf.Origin("targets")})
+
+	} else {
+		ans = append(ans, effectiveValue{"targetBinaryName", conf.TargetBinaryName, conf.Origin("targetBinaryName")})
+		ans = append(ans, effectiveValue{"cmdDir", conf.CmdDir, conf.Origin("cmdDir")})
+	}
+
+	if !ver.IsZero() {
+		ans = append(ans, effectiveValue{"variant", ver.ManateeVariant().Description, verOrigin})
+		recipeVal := effectiveValue{key: "recipe"}
+		if recipes, err := LoadRecipes(conf); err != nil {
+			recipeVal.value = "<unresolved: " + err.Error() + ">"
+
+		} else if recipe, err := recipes.Find(ver); err != nil {
+			recipeVal.value = "<not found>"
+
+		} else {
+			recipeVal.value = recipe.Versions
+			recipeVal.origin = recipe.Source()
+		}
+		ans = append(ans, recipeVal)
+	}
+
+	pcre := "pcre"
+	if conf.WithPCRE2 {
+		pcre = "pcre2"
+	}
+	ans = append(ans, effectiveValue{"withPcre2", pcre, conf.Origin("withPcre2")})
+	ans = append(ans, effectiveValue{"runTests", strconv.FormatBool(conf.RunTests), conf.Origin("runTests")})
+	ans = append(ans, effectiveValue{"stripSymbols", strconv.FormatBool(conf.StripSymbols), conf.Origin("stripSymbols")})
+	ans = append(ans, effectiveValue{"outputDir", conf.OutputDir, conf.Origin("outputDir")})
+	return ans
+}
+
+func showConfig(conf *Conf) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tORIGIN")
+	for _, v := range resolveEffectiveConf(conf) {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", v.key, v.value, v.origin)
+	}
+	tw.Flush()
+}
+
+// validateConfigs checks provided config files or, in case
+// no files are provided, all the config files applicable
+// to the project.
+func validateConfigs(paths []string, projectPath string) error {
+	if len(paths) == 0 {
+		if userPath := userConfigPath(); userPath != "" && fs.PathExists(userPath) {
+			paths = append(paths, userPath)
+		}
+		projPaths, err := findProjectConfigs(projectPath)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, projPaths...)
+	}
+	var numInvalid int
+	for _, p := range paths {
+		if err := ValidateConfigFile(p); err != nil {
+			color.New(color.FgHiRed).Fprintf(os.Stderr, "\u2717 %s:\n", p)
+			for _, line := range strings.Split(err.Error(), "\n") {
+				fmt.Fprintf(os.Stderr, "\t%s\n", line)
+			}
+			numInvalid++
+
+		} else {
+			fmt.Fprintf(os.Stderr, "\u2713 %s\n", p)
+		}
+	}
+	if numInvalid > 0 {
+		return fmt.Errorf("found %d invalid config file(s)", numInvalid)
+	}
+	return nil
+}
+
+func runConfigCommand(args []string, projectPath string, loadConf func() (*Conf, error)) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing config subcommand (available: show, schema, validate)")
+	}
+	switch args[0] {
+	case "show":
+		conf, err := loadConf()
+		if err != nil {
+			return err
+		}
+		showConfig(conf)
+		return nil
+	case "schema":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(ConfJSONSchema())
+	case "validate":
+		return validateConfigs(args[1:], projectPath)
+	default:
+		return fmt.Errorf("unknown config subcommand %s", args[0])
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func effectiveValuesByKey(values []effectiveValue) map[string]effectiveValue {
+	ans := make(map[string]effectiveValue)
+	for _, v := range values {
+		ans[v.key] = v
+	}
+	return ans
+}
+
+// loadConfFixture creates a project with the provided config file
+// and loads its configuration the same way manabuild does (incl.
+// environment variables and flags)
+func loadConfFixture(t *testing.T, content string, env EnvironmentVars, args ...string) (*Conf, string) {
+	t.Helper()
+	_, projectDir := createRepoFixture(t)
+	confPath := writeConfFile(t, projectDir, content)
+	fset := newConfFlagSet(t, args...)
+	fromEnv, err := applyEnvToFlags(fset, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := loadConfLayers(projectDir, "", env, fset, fromEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conf, "project config " + confPath
+}
+
+func TestResolveEffectiveConf(t *testing.T) {
+	libDir := createLibFixture(t, "testdata/libmanatee-2.225.8.so")
+	cacheDir := t.TempDir()
+	checksum := strings.Repeat("ab", 32)
+	conf, confOrigin := loadConfFixture(
+		t,
+		`{"manateeVersion": "2.225.8", "targetBinaryName": "mquery", "checksums": {"2.225.8": "`+checksum+`"}}`,
+		EnvironmentVars{"MANABUILD_CACHE_DIR": cacheDir},
+		"-manatee-lib", libDir,
+	)
+
+	values := effectiveValuesByKey(resolveEffectiveConf(conf))
+	tests := []struct {
+		key    string
+		value  string
+		origin string
+	}{
+		{"manateeVersion", "2.225.8", confOrigin},
+		{"cacheDir", cacheDir, "environment MANABUILD_CACHE_DIR"},
+		{"manateeSrc", filepath.Join(cacheDir, "manatee-open-2.225.8"), "download location"},
+		{"mirrors", expandMirrorTemplate(mirrorsFor(Version{2, 225, 8, ""}, []string{})[0], Version{2, 225, 8, ""}), "variant default"},
+		{"archiveChecksum", checksum, confOrigin},
+		{"manateeLib", libDir, "flag -manatee-lib"},
+		{"targetBinaryName", "mquery", confOrigin},
+		{"cmdDir", "", OriginDefault},
+		{"recipe", "", recipeSourceBuiltin},
+		{"withPcre2", "pcre", OriginDefault},
+		{"stripSymbols", "true", OriginDefault},
+	}
+	for _, tt := range tests {
+		v, ok := values[tt.key]
+		if !ok {
+			t.Errorf("missing key %s", tt.key)
+			continue
+		}
+		if tt.key == "mirrors" {
+			if !strings.HasPrefix(v.value, tt.value) || v.origin != tt.origin {
+				t.Errorf("unexpected %s: %s (%s)", tt.key, v.value, v.origin)
+			}
+			continue
+		}
+		if tt.key == "recipe" {
+			if v.value == "" || v.value == "<not found>" || v.origin != tt.origin {
+				t.Errorf("unexpected %s: %s (%s)", tt.key, v.value, v.origin)
+			}
+			continue
+		}
+		if v.value != tt.value || v.origin != tt.origin {
+			t.Errorf("%s = %s (%s), want %s (%s)", tt.key, v.value, v.origin, tt.value, tt.origin)
+		}
+	}
+}
+
+func TestResolveEffectiveConfConstraint(t *testing.T) {
+	libDir := createLibFixture(t, "testdata/libmanatee-2.225.8.so")
+	srcDir := "/usr/local/src/manatee-open-2.225.8"
+	conf, confOrigin := loadConfFixture(
+		t,
+		`{"manateeVersion": "~2.225", "cacheDir": "cache"}`,
+		EnvironmentVars{"MANABUILD_MANATEE_SRC": srcDir, "MANABUILD_MANATEE_LIB": libDir},
+	)
+
+	values := effectiveValuesByKey(resolveEffectiveConf(conf))
+	if v := values["manateeVersion"]; v.value != "2.225.8" || v.origin != confOrigin+" (constraint ~2.225)" {
+		t.Errorf("unexpected manateeVersion: %s (%s)", v.value, v.origin)
+	}
+	if v := values["manateeSrc"]; v.value != srcDir || v.origin != "environment MANABUILD_MANATEE_SRC" {
+		t.Errorf("unexpected manateeSrc: %s (%s)", v.value, v.origin)
+	}
+	if v := values["manateeLib"]; v.value != libDir || v.origin != "environment MANABUILD_MANATEE_LIB" {
+		t.Errorf("unexpected manateeLib: %s (%s)", v.value, v.origin)
+	}
+	if v := values["cacheDir"]; !filepath.IsAbs(v.value) || v.origin != confOrigin {
+		t.Errorf("unexpected cacheDir: %s (%s)", v.value, v.origin)
+	}
+	if _, ok := values["mirrors"]; ok {
+		t.Error("mirrors should not be shown for provided sources")
+	}
+}
+
+func TestResolveEffectiveConfUnresolvedVersion(t *testing.T) {
+	conf := NewConf()
+	conf.ManateeVersion = ">=9.0"
+	conf.ManateeLib = createLibFixture(t, "testdata/libmanatee-2.225.8.so")
+	conf.CacheDir = t.TempDir()
+	values := effectiveValuesByKey(resolveEffectiveConf(conf))
+	if v := values["manateeVersion"]; !strings.HasPrefix(v.value, "<unresolved: ") {
+		t.Errorf("expected unresolved version, got %s", v.value)
+	}
+	if v := values["manateeSrc"]; v.value != "<unresolved>" {
+		t.Errorf("expected unresolved sources, got %s", v.value)
+	}
+}
@@ -19,80 +19,114 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
-	"os/exec"
 	"path"
+	"path/filepath"
 
 	"github.com/czcorpus/cnc-gokit/fs"
+	"github.com/fatih/color"
 )
 
-func downloadFile(url, target string) error {
-	outf, err := os.Create(target)
-	if err != nil {
-		return err
-	}
-	defer outf.Close()
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
-		return fmt.Errorf("failed to download %s with status: %d", url, resp.StatusCode)
-	}
-	_, err = io.Copy(outf, resp.Body)
-	return err
+// manateeSrcDir returns a directory where downloaded
+// sources of a specified version are unpacked. Each variant
+// has its own directory.
+func manateeSrcDir(cacheDir string, ver Version) string {
+	return filepath.Join(cacheDir, manateeSrcDirName(ver))
 }
 
-func unpackArchive(path string) error {
-	cmd := exec.Command("tar", "xzf", path, "-C", "/tmp")
-	err := cmd.Run()
-	if err != nil {
-		os.Remove(path)
-		fmt.Fprintf(os.Stderr, "removing archive %s due to an error\n", path)
-		return fmt.Errorf("failed to unpack file %s: %w", path, err)
+func manateeSrcDirName(ver Version) string {
+	return "manatee-open-" + ver.Full()
+}
+
+// manateeArchivePath returns a path of a downloaded source
+// archive of a specified version
+func manateeArchivePath(cacheDir string, ver Version) string {
+	return filepath.Join(cacheDir, manateeArchiveName(ver))
+}
+
+func manateeArchiveName(ver Version) string {
+	return fmt.Sprintf("manatee-open-%s.tar.gz", ver.Full())
+}
+
+// verifyManateeArchive checks a source archive against a known
+// checksum. An archive failing the check is removed. A missing
+// checksum is always an error for versions listed in KnownVersions.
+// For other versions (unknown ones, versions with recipes defined
+// in config), it is an error only if requireChecksum is set.
+func verifyManateeArchive(ver Version, archivePath string, checksums *ChecksumRegistry, requireChecksum bool) error {
+	err := checksums.Verify(ver, archivePath)
+	if errors.Is(err, ErrNoChecksum) {
+		if requireChecksum || isKnownVersion(ver, KnownVersions) {
+			return fmt.Errorf("%w (add it to `checksums` in config)", err)
+		}
+		color.New(color.FgHiYellow).Fprintf(
+			os.Stderr, "\n \u24D8  WARNING: %s (add it to `checksums` in config)\n", err)
+		return nil
+
+	} else if errors.Is(err, ErrChecksumMismatch) {
+		os.Remove(archivePath)
+		fmt.Fprintf(os.Stderr, "removing archive %s due to checksum mismatch\n", archivePath)
 	}
-	return nil
+	return err
 }
 
-func downloadManateeSrc(ver Version) (string, error) {
+func downloadManateeSrc(
+	seq *OperationSequence,
+	dl *Downloader,
+	ver Version,
+	recipe BuildRecipe,
+	cacheDir string,
+	mirrors []string,
+	checksums *ChecksumRegistry,
+	requireChecksum bool,
+) (string, error) {
 	errTpl := "Failed to download and extract manatee-open: %w. Please do this manually and run the script with --manatee-src"
-	outDir := fmt.Sprintf("/tmp/manatee-open-%s", ver.Semver())
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory %s: %w", cacheDir, err)
+	}
+	outDir := manateeSrcDir(cacheDir, ver)
+	outFile := manateeArchivePath(cacheDir, ver)
 	var err error
 	isDir, err := fs.IsDir(outDir)
 	if err != nil {
 		return "", fmt.Errorf("failed to explore directory %s: %w", outDir, err)
 	}
 	if isDir {
-		fmt.Fprintf(os.Stderr, "found existing manatee directory in %s\n", outDir)
-		return outDir, nil
+		err := validateSrcTree(outDir, ver, recipe, outFile)
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "found existing manatee directory in %s\n", outDir)
+			touchCacheEntry(outDir)
+			return outDir, nil
+
+		} else if !errors.Is(err, ErrIncompleteSrcTree) {
+			return "", fmt.Errorf("failed to validate directory %s: %w", outDir, err)
+		}
+		fmt.Fprintf(os.Stderr, "existing manatee directory %s cannot be used (%s), extracting again\n", outDir, err)
+		if err := os.RemoveAll(outDir); err != nil {
+			return "", fmt.Errorf("failed to remove directory %s: %w", outDir, err)
+		}
 	}
-	outFile := fmt.Sprintf("/tmp/manatee-open-%s.tar.gz", ver.Semver())
 	fmt.Fprintf(os.Stderr, "\nLooking for %s\n", path.Base(outFile))
 	if !fs.PathExists(outFile) {
-		url := fmt.Sprintf(
-			"https://corpora.fi.muni.cz/noske/src/manatee-open/manatee-open-%s.tar.gz",
-			ver.Semver())
-		if err = downloadFile(url, outFile); err != nil {
-			url = fmt.Sprintf(
-				"https://corpora.fi.muni.cz/noske/src/manatee-open/archive/manatee-open-%s.tar.gz",
-				ver.Semver())
-			if err = downloadFile(url, outFile); err != nil {
-				url = fmt.Sprintf(
-					"http://corpora.fi.muni.cz/noske/current/src/manatee-open-%s.tar.gz",
-					ver.Semver())
-				if err = downloadFile(url, outFile); err != nil {
-					return "", fmt.Errorf(errTpl, err)
-				}
-			}
+		dl.Progress = seq.Progress("downloading")
+		err := fetchManateeArchive(context.Background(), dl, ver, mirrorsFor(ver, mirrors), outFile)
+		if err != nil {
+			return "", fmt.Errorf(errTpl, err)
 		}
 	}
-	err = unpackArchive(outFile)
+	if err := verifyManateeArchive(ver, outFile, checksums, requireChecksum); err != nil {
+		return "", err
+	}
+	touchCacheEntry(outFile)
+	err = unpackArchive(outFile, outDir, seq.Progress("extracting"))
 	if err != nil {
 		return "", fmt.Errorf(errTpl, err)
 	}
+	if err := markSrcTreeComplete(outDir, ver, recipe, outFile); err != nil {
+		return "", fmt.Errorf(errTpl, err)
+	}
 	return outDir, nil
 }
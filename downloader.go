@@ -0,0 +1,246 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultDownloadTimeout = 10 * time.Minute
+	DefaultDownloadRetries = 3
+
+	downloadPartSuffix = ".part"
+)
+
+// errPermanent marks download errors which cannot be fixed by retrying
+type errPermanent struct {
+	err error
+}
+
+func (e errPermanent) Error() string {
+	return e.err.Error()
+}
+
+func (e errPermanent) Unwrap() error {
+	return e.err
+}
+
+// Downloader fetches files via HTTP(S). Each attempt is limited
+// by a timeout, failed attempts are retried with an exponential
+// backoff and interrupted downloads are resumed via Range requests.
+// The target file is written only once the download is complete.
+type Downloader struct {
+	Client *http.Client
+
+	// Timeout limits a single download attempt
+	Timeout time.Duration
+
+	// Retries specifies how many times a failed attempt is repeated
+	// (a negative value is treated as zero)
+	Retries int
+
+	// Backoff is a delay before the first retry (doubled
+	// with each following one up to MaxBackoff)
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Progress (if set) is called as data are received
+	Progress progressFunc
+}
+
+// NewDownloader creates a downloader with the system proxy
+// settings (HTTP_PROXY, HTTPS_PROXY, NO_PROXY)
+func NewDownloader(timeout time.Duration, retries int) *Downloader {
+	return &Downloader{
+		Client: &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{
+					Timeout:   30 * time.Second,
+					KeepAlive: 30 * time.Second,
+				}).DialContext,
+				TLSHandshakeTimeout:   15 * time.Second,
+				ResponseHeaderTimeout: 30 * time.Second,
+			},
+		},
+		Timeout:    timeout,
+		Retries:    retries,
+		Backoff:    time.Second,
+		MaxBackoff: 30 * time.Second,
+	}
+}
+
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= 500
+}
+
+// attempt performs a single download attempt appending data
+// to an already downloaded part (if any)
+func (d *Downloader) attempt(ctx context.Context, url, partPath string) error {
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return errPermanent{err}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		cr := resp.Header.Get("Content-Range")
+		if start, ok := contentRangeStart(cr); !ok || start != offset {
+			// appending a different range would corrupt the file => start over
+			os.Remove(partPath)
+			return fmt.Errorf("failed to resume download of %s: unexpected Content-Range %q", url, cr)
+		}
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// the part is probably stale (e.g. the file has changed) => start over
+		os.Remove(partPath)
+		return fmt.Errorf("failed to resume download of %s", url)
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		// the server ignored the Range header => start over
+		offset = 0
+		flags |= os.O_TRUNC
+	case isRetryableStatus(resp.StatusCode):
+		return fmt.Errorf("failed to download %s with status: %d", url, resp.StatusCode)
+	default:
+		return errPermanent{fmt.Errorf("failed to download %s with status: %d", url, resp.StatusCode)}
+	}
+
+	var total int64 = -1
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	outf, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return errPermanent{err}
+	}
+	body := &countingReader{r: resp.Body, done: offset, total: total, progress: d.Progress}
+	_, err = io.Copy(outf, body)
+	if cerr := outf.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	if total >= 0 && body.done != total {
+		return fmt.Errorf("failed to download %s: incomplete data (%d of %d bytes)", url, body.done, total)
+	}
+	return nil
+}
+
+// contentRangeStart returns the first byte position of
+// a Content-Range header value (e.g. "bytes 100-199/200")
+func contentRangeStart(value string) (int64, bool) {
+	rng, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, false
+	}
+	ans, err := strconv.ParseInt(start, 10, 64)
+	if err != nil || ans < 0 {
+		return 0, false
+	}
+	return ans, true
+}
+
+// downloadPartPath returns a path of a file containing unfinished
+// download of url to target. The path is specific to the url so
+// a download is never resumed using data of a different source.
+func downloadPartPath(url, target string) string {
+	sum := sha256.Sum256([]byte(url))
+	return fmt.Sprintf("%s.%s%s", target, hex.EncodeToString(sum[:4]), downloadPartSuffix)
+}
+
+// Download fetches url into target. Data are written to a part file
+// (see downloadPartPath) which is renamed to target once the download
+// is complete. In case the part file exists (e.g. from an interrupted
+// run), the download is resumed.
+func (d *Downloader) Download(ctx context.Context, url, target string) error {
+	partPath := downloadPartPath(url, target)
+	backoff := d.Backoff
+	retries := d.Retries
+	if retries < 0 {
+		retries = 0
+	}
+	var err error
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if d.MaxBackoff > 0 && backoff > d.MaxBackoff {
+				backoff = d.MaxBackoff
+			}
+		}
+		err = d.attempt(ctx, url, partPath)
+		if err == nil {
+			return os.Rename(partPath, target)
+		}
+		var perm errPermanent
+		if errors.As(err, &perm) || ctx.Err() != nil {
+			break
+		}
+	}
+	return err
+}
+
+// formatProgress creates a human-readable description
+// of a download/extraction progress
+func formatProgress(done, total int64) string {
+	if total <= 0 {
+		return formatSize(done)
+	}
+	return fmt.Sprintf(
+		"%s / %s (%s%%)", formatSize(done), formatSize(total), strconv.FormatInt(done*100/total, 10))
+}
@@ -0,0 +1,264 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testDownloader() *Downloader {
+	dl := NewDownloader(5*time.Second, 3)
+	dl.Backoff = time.Millisecond
+	dl.MaxBackoff = 5 * time.Millisecond
+	return dl
+}
+
+var testPayload = strings.Repeat("manatee-open source archive ", 1000)
+
+// rangeHandler serves testPayload with Range support. The first
+// `failures` responses are produced by the fail function.
+func rangeHandler(failures int32, fail func(w http.ResponseWriter, r *http.Request)) (http.Handler, *int32) {
+	var requests int32
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		if n <= failures {
+			fail(w, r)
+			return
+		}
+		data := testPayload
+		if rng := r.Header.Get("Range"); rng != "" {
+			start, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			if err != nil || start >= len(data) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(data)-1, len(data)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)-start))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(data[start:]))
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write([]byte(data))
+	}), &requests
+}
+
+func checkDownloaded(t *testing.T, target string) {
+	t.Helper()
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != testPayload {
+		t.Errorf("downloaded data differ (%d bytes, expected %d)", len(data), len(testPayload))
+	}
+	if parts, _ := filepath.Glob(target + "*" + downloadPartSuffix); len(parts) > 0 {
+		t.Errorf("part files should not exist after a successful download, found %v", parts)
+	}
+}
+
+func TestDownloadWithProgress(t *testing.T) {
+	handler, _ := rangeHandler(0, nil)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	target := filepath.Join(t.TempDir(), "archive.tar.gz")
+	dl := testDownloader()
+	var lastDone, lastTotal int64
+	dl.Progress = func(done, total int64) {
+		lastDone, lastTotal = done, total
+	}
+	if err := dl.Download(context.Background(), srv.URL, target); err != nil {
+		t.Fatal(err)
+	}
+	checkDownloaded(t, target)
+	if lastDone != int64(len(testPayload)) || lastTotal != int64(len(testPayload)) {
+		t.Errorf("unexpected final progress %d/%d", lastDone, lastTotal)
+	}
+}
+
+func TestDownloadRetriesServerErrors(t *testing.T) {
+	handler, requests := rangeHandler(2, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	target := filepath.Join(t.TempDir(), "archive.tar.gz")
+	if err := testDownloader().Download(context.Background(), srv.URL, target); err != nil {
+		t.Fatal(err)
+	}
+	checkDownloaded(t, target)
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Errorf("expected 3 requests, got %d", n)
+	}
+}
+
+func TestDownloadNegativeRetries(t *testing.T) {
+	handler, requests := rangeHandler(0, nil)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	target := filepath.Join(t.TempDir(), "archive.tar.gz")
+	dl := testDownloader()
+	dl.Retries = -1
+	if err := dl.Download(context.Background(), srv.URL, target); err != nil {
+		t.Fatal(err)
+	}
+	checkDownloaded(t, target)
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("expected 1 request, got %d", n)
+	}
+}
+
+func TestDownloadResumesTruncatedTransfer(t *testing.T) {
+	var rangeRequested int32
+	handler, _ := rangeHandler(1, func(w http.ResponseWriter, r *http.Request) {
+		// announce full length but send only a half and drop the connection
+		w.Header().Set("Content-Length", strconv.Itoa(len(testPayload)))
+		w.Write([]byte(testPayload[:len(testPayload)/2]))
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			atomic.StoreInt32(&rangeRequested, 1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	target := filepath.Join(t.TempDir(), "archive.tar.gz")
+	if err := testDownloader().Download(context.Background(), srv.URL, target); err != nil {
+		t.Fatal(err)
+	}
+	checkDownloaded(t, target)
+	if atomic.LoadInt32(&rangeRequested) != 1 {
+		t.Error("expected the download to be resumed via a Range request")
+	}
+}
+
+func TestDownloadRestartsOnUnexpectedContentRange(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&requests, 1) {
+		case 1:
+			w.Header().Set("Content-Length", strconv.Itoa(len(testPayload)))
+			w.Write([]byte(testPayload[:len(testPayload)/2]))
+			w.(http.Flusher).Flush()
+			if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+				conn.Close()
+			}
+		case 2:
+			// a broken proxy ignoring the requested offset
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(testPayload)-1, len(testPayload)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(testPayload)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(testPayload))
+		default:
+			if r.Header.Get("Range") != "" {
+				t.Error("download should be restarted from the beginning")
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(testPayload)))
+			w.Write([]byte(testPayload))
+		}
+	}))
+	defer srv.Close()
+	target := filepath.Join(t.TempDir(), "archive.tar.gz")
+	if err := testDownloader().Download(context.Background(), srv.URL, target); err != nil {
+		t.Fatal(err)
+	}
+	checkDownloaded(t, target)
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("expected 3 requests, got %d", n)
+	}
+}
+
+func TestContentRangeStart(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		ok    bool
+	}{
+		{"bytes 100-199/200", 100, true},
+		{"bytes 0-99/*", 0, true},
+		{"bytes */200", 0, false},
+		{"items 100-199/200", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		if got, ok := contentRangeStart(tt.value); got != tt.want || ok != tt.ok {
+			t.Errorf("contentRangeStart(%q) = %d, %t, want %d, %t", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDownloadPermanentFailure(t *testing.T) {
+	handler, requests := rangeHandler(100, func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	target := filepath.Join(t.TempDir(), "archive.tar.gz")
+	err := testDownloader().Download(context.Background(), srv.URL, target)
+	var perm errPermanent
+	if !errors.As(err, &perm) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("a missing file should not be retried, got %d requests", n)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Error("target should not exist after a failed download")
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	handler, requests := rangeHandler(100, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	target := filepath.Join(t.TempDir(), "archive.tar.gz")
+	dl := testDownloader()
+	dl.Timeout = 50 * time.Millisecond
+	dl.Retries = 1
+	if err := dl.Download(context.Background(), srv.URL, target); err == nil {
+		t.Error("expected timeout error")
+	}
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Errorf("expected 2 attempts, got %d", n)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Error("target should not exist after a failed download")
+	}
+}
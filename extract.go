@@ -0,0 +1,288 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	ErrUnsafeArchivePath = errors.New("unsafe path in archive")
+
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicBzip2 = []byte("BZh")
+	magicXz    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// progressFunc reports a progress of a long-running operation.
+// In case the total is unknown, it is set to -1.
+type progressFunc func(done, total int64)
+
+// countingReader reports the number of bytes read so far
+type countingReader struct {
+	r        io.Reader
+	done     int64
+	total    int64
+	progress progressFunc
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.done += int64(n)
+	if cr.progress != nil && n > 0 {
+		cr.progress(cr.done, cr.total)
+	}
+	return n, err
+}
+
+// decompressingReader detects a compression of a stream
+// (gzip, bzip2, xz or none) based on its magic bytes.
+func decompressingReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(magicXz))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(head, magicGzip):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(head, magicBzip2):
+		return bzip2.NewReader(br), nil
+	case bytes.HasPrefix(head, magicXz):
+		return xz.NewReader(br)
+	default:
+		return br, nil
+	}
+}
+
+// safeArchivePath validates a path of an archive entry. Absolute paths
+// and paths leading outside of the extraction directory are rejected.
+func safeArchivePath(name string) (string, error) {
+	if filepath.IsAbs(name) || !filepath.IsLocal(name) {
+		return "", fmt.Errorf("%w: %s", ErrUnsafeArchivePath, name)
+	}
+	return filepath.Clean(name), nil
+}
+
+// safeLinkTarget validates a target of a link entry. For symlinks,
+// the target is relative to the link location, for hard links,
+// it is relative to the archive root.
+func safeLinkTarget(name, target string, isSymlink bool) error {
+	resolved := target
+	if isSymlink {
+		if filepath.IsAbs(target) {
+			return fmt.Errorf("%w: %s -> %s", ErrUnsafeArchivePath, name, target)
+		}
+		resolved = filepath.Join(filepath.Dir(name), target)
+	}
+	if !filepath.IsLocal(resolved) {
+		return fmt.Errorf("%w: %s -> %s", ErrUnsafeArchivePath, name, target)
+	}
+	return nil
+}
+
+// noSymlinkParents tests whether any of the existing parent
+// directories of an entry within destDir is a symlink. Writing
+// through such a symlink (created earlier by the same archive)
+// could escape destDir even if all the entry paths are local.
+func noSymlinkParents(destDir, name string) error {
+	curr := destDir
+	for _, item := range strings.Split(filepath.Dir(name), string(filepath.Separator)) {
+		if item == "." {
+			continue
+		}
+		curr = filepath.Join(curr, item)
+		info, err := os.Lstat(curr)
+		if os.IsNotExist(err) {
+			return nil
+
+		} else if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s (path contains a symlink)", ErrUnsafeArchivePath, name)
+		}
+	}
+	return nil
+}
+
+func extractFile(tr io.Reader, dest string, hdr *tar.Header) error {
+	outf, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, hdr.FileInfo().Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(outf, tr); err != nil {
+		outf.Close()
+		return err
+	}
+	return outf.Close()
+}
+
+// extractArchive extracts a (possibly compressed) tar archive into
+// destDir which must exist. Entries with unsafe paths cause the
+// extraction to fail. Modification times of files are preserved
+// as build tools depend on them.
+func extractArchive(archivePath, destDir string, progress progressFunc) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var total int64 = -1
+	if info, err := f.Stat(); err == nil {
+		total = info.Size()
+	}
+	r, err := decompressingReader(&countingReader{r: f, total: total, progress: progress})
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", archivePath, err)
+	}
+	tr := tar.NewReader(r)
+	type dirTime struct {
+		path  string
+		mtime time.Time
+	}
+	dirTimes := make([]dirTime, 0, 100)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+
+		} else if err != nil {
+			return fmt.Errorf("failed to read %s: %w", archivePath, err)
+		}
+		name, err := safeArchivePath(hdr.Name)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(destDir, name)
+		if err := noSymlinkParents(destDir, name); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeDir {
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return err
+			}
+			// an existing entry is replaced (and never written through
+			// in case it is a symlink)
+			if info, err := os.Lstat(dest); err == nil && !info.IsDir() {
+				if err := os.Remove(dest); err != nil {
+					return err
+				}
+			}
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if info, err := os.Lstat(dest); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				return fmt.Errorf("%w: %s (path contains a symlink)", ErrUnsafeArchivePath, name)
+			}
+			if err := os.MkdirAll(dest, hdr.FileInfo().Mode().Perm()|0700); err != nil {
+				return err
+			}
+			dirTimes = append(dirTimes, dirTime{dest, hdr.ModTime})
+		case tar.TypeReg, tar.TypeRegA:
+			if err := extractFile(tr, dest, hdr); err != nil {
+				return fmt.Errorf("failed to extract %s: %w", name, err)
+			}
+			os.Chtimes(dest, hdr.ModTime, hdr.ModTime)
+		case tar.TypeSymlink:
+			if err := safeLinkTarget(name, hdr.Linkname, true); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, dest); err != nil {
+				return fmt.Errorf("failed to extract %s: %w", name, err)
+			}
+		case tar.TypeLink:
+			if err := safeLinkTarget(name, hdr.Linkname, false); err != nil {
+				return err
+			}
+			if err := noSymlinkParents(destDir, filepath.Clean(hdr.Linkname)); err != nil {
+				return err
+			}
+			if err := os.Link(filepath.Join(destDir, filepath.Clean(hdr.Linkname)), dest); err != nil {
+				return fmt.Errorf("failed to extract %s: %w", name, err)
+			}
+		default:
+			// devices, FIFOs etc. are not expected in source archives
+		}
+	}
+	// directory times must be set once all their entries are written
+	for i := len(dirTimes) - 1; i >= 0; i-- {
+		os.Chtimes(dirTimes[i].path, dirTimes[i].mtime, dirTimes[i].mtime)
+	}
+	return nil
+}
+
+// archiveRootDir returns a single top-level directory of extracted
+// archive contents. In case the archive does not have a single
+// top-level directory, dir itself is returned.
+func archiveRootDir(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), nil
+	}
+	return dir, nil
+}
+
+// unpackArchive extracts a Manatee source archive into outDir.
+// The archive is extracted into a temporary directory next to outDir
+// first and its top-level directory (whatever its name is) is then
+// moved to outDir. In case of an error, the archive is removed.
+func unpackArchive(archivePath, outDir string, progress progressFunc) error {
+	tmpDir, err := os.MkdirTemp(filepath.Dir(outDir), ".extract-")
+	if err != nil {
+		return fmt.Errorf("failed to create extraction directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := extractArchive(archivePath, tmpDir, progress); err != nil {
+		os.Remove(archivePath)
+		fmt.Fprintf(os.Stderr, "removing archive %s due to an error\n", archivePath)
+		return fmt.Errorf("failed to unpack file %s: %w", archivePath, err)
+	}
+	root, err := archiveRootDir(tmpDir)
+	if err != nil {
+		return fmt.Errorf("failed to unpack file %s: %w", archivePath, err)
+	}
+	if root == tmpDir {
+		// keep the temporary directory permissions consistent
+		// with regular extracted directories
+		if err := os.Chmod(tmpDir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(root, outDir); err != nil {
+		return fmt.Errorf("failed to move unpacked sources to %s: %w", outDir, err)
+	}
+	return nil
+}
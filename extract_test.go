@@ -0,0 +1,191 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ulikunitz/xz"
+)
+
+type tarItem struct {
+	hdr  tar.Header
+	body string
+}
+
+var testArchiveMtime = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func writeTestArchive(t *testing.T, compression string, items []tarItem) string {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch compression {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "xz":
+		xw, err := xz.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = xw
+	default:
+		w = nopWriteCloser{&buf}
+	}
+	tw := tar.NewWriter(w)
+	for _, item := range items {
+		hdr := item.hdr
+		hdr.Size = int64(len(item.body))
+		hdr.ModTime = testArchiveMtime
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(item.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archivePath := filepath.Join(t.TempDir(), "archive.tar.gz")
+	if err := os.WriteFile(archivePath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return archivePath
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func manateeTestItems(topDir string) []tarItem {
+	return []tarItem{
+		{hdr: tar.Header{Name: topDir + "/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: topDir + "/configure", Typeflag: tar.TypeReg, Mode: 0755}, body: "#!/bin/sh\n"},
+		{hdr: tar.Header{Name: topDir + "/corp/corpus.hh", Typeflag: tar.TypeReg}, body: "// corpus\n"},
+		{hdr: tar.Header{Name: topDir + "/corp/link.hh", Typeflag: tar.TypeSymlink, Linkname: "corpus.hh"}},
+	}
+}
+
+func TestUnpackArchive(t *testing.T) {
+	for _, compression := range []string{"gzip", "xz", "none"} {
+		t.Run(compression, func(t *testing.T) {
+			// the top-level directory does not follow manatee-open-<semver>
+			archivePath := writeTestArchive(t, compression, manateeTestItems("manatee-open-2.225.8-rc1"))
+			outDir := filepath.Join(t.TempDir(), "manatee-open-2.225.8")
+			var lastDone, lastTotal int64
+			err := unpackArchive(archivePath, outDir, func(done, total int64) {
+				lastDone, lastTotal = done, total
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(filepath.Join(outDir, "configure"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0755 {
+				t.Errorf("expected executable configure, got %s", info.Mode())
+			}
+			if !info.ModTime().Equal(testArchiveMtime) {
+				t.Errorf("modification time not preserved: %s", info.ModTime())
+			}
+			if data, err := os.ReadFile(filepath.Join(outDir, "corp", "link.hh")); err != nil || string(data) != "// corpus\n" {
+				t.Errorf("unexpected symlink contents %q (%v)", data, err)
+			}
+			if lastTotal <= 0 || lastDone != lastTotal {
+				t.Errorf("unexpected final progress %d/%d", lastDone, lastTotal)
+			}
+		})
+	}
+}
+
+func TestUnpackArchiveWithoutTopLevelDir(t *testing.T) {
+	archivePath := writeTestArchive(t, "gzip", []tarItem{
+		{hdr: tar.Header{Name: "configure", Typeflag: tar.TypeReg, Mode: 0755}, body: "#!/bin/sh\n"},
+		{hdr: tar.Header{Name: "corp/corpus.hh", Typeflag: tar.TypeReg}, body: "// corpus\n"},
+	})
+	outDir := filepath.Join(t.TempDir(), "manatee-open-2.225.8")
+	if err := unpackArchive(archivePath, outDir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "corp", "corpus.hh")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnpackArchiveRejectsUnsafePaths(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []tarItem
+	}{
+		{"parent dir", []tarItem{{hdr: tar.Header{Name: "manatee/../../evil", Typeflag: tar.TypeReg}, body: "x"}}},
+		{"absolute", []tarItem{{hdr: tar.Header{Name: "/tmp/evil", Typeflag: tar.TypeReg}, body: "x"}}},
+		{"symlink escape", []tarItem{{hdr: tar.Header{Name: "manatee/evil", Typeflag: tar.TypeSymlink, Linkname: "../../etc/passwd"}}}},
+		{"absolute symlink", []tarItem{{hdr: tar.Header{Name: "manatee/evil", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}}}},
+		{"hard link escape", []tarItem{{hdr: tar.Header{Name: "manatee/evil", Typeflag: tar.TypeLink, Linkname: "../etc/passwd"}}}},
+		{"write via chained symlinks", []tarItem{
+			{hdr: tar.Header{Name: "deep", Typeflag: tar.TypeSymlink, Linkname: "."}},
+			{hdr: tar.Header{Name: "deep/a/b/link", Typeflag: tar.TypeSymlink, Linkname: "../../.."}},
+			{hdr: tar.Header{Name: "deep/a/b/link/pwned.txt", Typeflag: tar.TypeReg}, body: "x"},
+		}},
+		{"directory via symlink", []tarItem{
+			{hdr: tar.Header{Name: "manatee/", Typeflag: tar.TypeDir, Mode: 0755}},
+			{hdr: tar.Header{Name: "manatee/up", Typeflag: tar.TypeSymlink, Linkname: "."}},
+			{hdr: tar.Header{Name: "manatee/up/", Typeflag: tar.TypeDir, Mode: 0755}},
+		}},
+		{"hard link via symlink", []tarItem{
+			{hdr: tar.Header{Name: "manatee/", Typeflag: tar.TypeDir, Mode: 0755}},
+			{hdr: tar.Header{Name: "manatee/up", Typeflag: tar.TypeSymlink, Linkname: "."}},
+			{hdr: tar.Header{Name: "manatee/evil", Typeflag: tar.TypeLink, Linkname: "manatee/up/x"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archivePath := writeTestArchive(t, "gzip", tt.items)
+			parent := t.TempDir()
+			outDir := filepath.Join(parent, "manatee-open-2.225.8")
+			err := unpackArchive(archivePath, outDir, nil)
+			if !errors.Is(err, ErrUnsafeArchivePath) {
+				t.Errorf("expected ErrUnsafeArchivePath, got %v", err)
+			}
+			entries, _ := os.ReadDir(parent)
+			if len(entries) != 0 {
+				t.Errorf("nothing should be left after a failed extraction, found %v", entries)
+			}
+		})
+	}
+}
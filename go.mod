@@ -6,6 +6,7 @@ require (
 	github.com/briandowns/spinner v1.23.0
 	github.com/czcorpus/cnc-gokit v0.3.6
 	github.com/fatih/color v1.15.0
+	github.com/ulikunitz/xz v0.5.12
 )
 
 require (
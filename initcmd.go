@@ -0,0 +1,242 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"debug/elf"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	gfs "github.com/czcorpus/cnc-gokit/fs"
+	"github.com/fatih/color"
+)
+
+var (
+	majorVersionSuffix = regexp.MustCompile(`^v\d+$`)
+)
+
+// initConf is a subset of Conf written by the `init` command
+type initConf struct {
+	ManateeVersion   string        `json:"manateeVersion,omitempty"`
+	TargetBinaryName string        `json:"targetBinaryName,omitempty"`
+	CmdDir           string        `json:"cmdDir,omitempty"`
+	Targets          []BuildTarget `json:"targets,omitempty"`
+	WithPCRE2        bool          `json:"withPcre2"`
+	RunTests         bool          `json:"runTests"`
+}
+
+// readModulePath returns a module path as defined in go.mod
+func readModulePath(projectPath string) (string, error) {
+	f, err := os.Open(filepath.Join(projectPath, "go.mod"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read go.mod: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "module ") {
+			return strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "module ")), `"`), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read go.mod: %w", err)
+	}
+	return "", fmt.Errorf("no module directive found in go.mod")
+}
+
+// binaryNameFromModule derives a binary name from a module
+// path (github.com/foo/bar/v2 => bar)
+func binaryNameFromModule(modPath string) string {
+	items := strings.Split(modPath, "/")
+	if len(items) > 1 && majorVersionSuffix.MatchString(items[len(items)-1]) {
+		items = items[:len(items)-1]
+	}
+	return items[len(items)-1]
+}
+
+// findCmdDirs returns all the subdirectories of `cmd`
+// containing Go source files
+func findCmdDirs(projectPath string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(projectPath, "cmd"))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+
+	} else if err != nil {
+		return []string{}, fmt.Errorf("failed to list cmd directory: %w", err)
+	}
+	ans := make([]string, 0, len(entries))
+	for _, ent := range entries {
+		if !ent.IsDir() {
+			continue
+		}
+		goFiles, err := filepath.Glob(filepath.Join(projectPath, "cmd", ent.Name(), "*.go"))
+		if err != nil {
+			return []string{}, err
+		}
+		if len(goFiles) > 0 {
+			ans = append(ans, ent.Name())
+		}
+	}
+	return ans, nil
+}
+
+// hasTests tests whether there is at least one _test.go file
+// within the project
+func hasTests(projectPath string) bool {
+	var found bool
+	filepath.WalkDir(projectPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // unreadable entries are just ignored
+		}
+		if d.IsDir() && path != projectPath &&
+			(d.Name() == "vendor" || strings.HasPrefix(d.Name(), ".")) {
+			return filepath.SkipDir
+		}
+		if strings.HasSuffix(d.Name(), "_test.go") {
+			found = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
+// libUsesPCRE2 tests whether a libmanatee.so located in libDir
+// is linked against PCRE2
+func libUsesPCRE2(libDir string) (bool, error) {
+	f, err := elf.Open(filepath.Join(libDir, "libmanatee.so"))
+	if err != nil {
+		return false, fmt.Errorf("failed to open libmanatee.so: %w", err)
+	}
+	defer f.Close()
+	libs, err := f.ImportedLibraries()
+	if err != nil {
+		return false, fmt.Errorf("failed to read dependencies of libmanatee.so: %w", err)
+	}
+	for _, lib := range libs {
+		if strings.HasPrefix(lib, "libpcre2") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// createInitConf inspects a project and installed Manatee
+// and suggests a respective configuration.
+func createInitConf(projectPath, manateeLib string) (initConf, []string, error) {
+	var ans initConf
+	notes := make([]string, 0, 5)
+	modPath, err := readModulePath(projectPath)
+	if err != nil {
+		return ans, notes, err
+	}
+	cmdDirs, err := findCmdDirs(projectPath)
+	if err != nil {
+		return ans, notes, err
+	}
+	switch len(cmdDirs) {
+	case 0:
+		ans.TargetBinaryName = binaryNameFromModule(modPath)
+		notes = append(notes, fmt.Sprintf("no cmd/* packages found, building %s from the project root", modPath))
+	case 1:
+		ans.TargetBinaryName = cmdDirs[0]
+		ans.CmdDir = cmdDirs[0]
+		notes = append(notes, fmt.Sprintf("found cmd/%s", cmdDirs[0]))
+	default:
+		for _, d := range cmdDirs {
+			ans.Targets = append(ans.Targets, BuildTarget{BinaryName: d, CmdDir: d})
+		}
+		notes = append(notes, fmt.Sprintf("found %d cmd/* packages: %s", len(cmdDirs), strings.Join(cmdDirs, ", ")))
+	}
+	if hasTests(projectPath) {
+		notes = append(notes, "found unit tests, consider enabling runTests")
+	}
+
+	ver, err := AutodetectManateeVersion(manateeLib, KnownVersions)
+	if err != nil || ver.IsZero() {
+		notes = append(notes, "no installed Manatee detected, please set manateeVersion manually")
+
+	} else {
+		ans.ManateeVersion = ver.Full()
+		notes = append(notes, fmt.Sprintf("detected Manatee %s (%s)", ver.Full(), ver.ManateeVariant().Description))
+		libDir := manateeLib
+		if libDir == "" {
+			libDir, _ = findManatee(ver)
+		}
+		if libDir != "" {
+			usesPCRE2, err := libUsesPCRE2(libDir)
+			if err != nil {
+				notes = append(notes, fmt.Sprintf("failed to determine PCRE flavor: %s", err))
+
+			} else {
+				ans.WithPCRE2 = usesPCRE2
+				if usesPCRE2 {
+					notes = append(notes, fmt.Sprintf("%s/libmanatee.so is linked against PCRE2", libDir))
+				}
+			}
+		}
+	}
+	return ans, notes, nil
+}
+
+func runInitCommand(args []string, projectPath, manateeLib string) error {
+	fset := flag.NewFlagSet("init", flag.ContinueOnError)
+	overwrite := fset.Bool("overwrite", false, "Overwrite an existing .manabuild.json")
+	dryRun := fset.Bool("dry-run", false, "Just print the generated config to stdout")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	confPath := filepath.Join(projectPath, confFileName)
+	if !*dryRun && !*overwrite && gfs.PathExists(confPath) {
+		return fmt.Errorf("%s already exists (use -overwrite to replace it)", confPath)
+	}
+	conf, notes, err := createInitConf(projectPath, manateeLib)
+	if err != nil {
+		return fmt.Errorf("failed to inspect project: %w", err)
+	}
+	for _, note := range notes {
+		color.New(color.FgHiYellow).Fprintf(os.Stderr, "\n \u24D8  %s", note)
+	}
+	fmt.Fprintln(os.Stderr)
+	data, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if err := validateConfigData(data); err != nil {
+		return fmt.Errorf("generated config is invalid: %w", err)
+	}
+	if *dryRun {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(confPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", confPath, err)
+	}
+	fmt.Fprintf(os.Stderr, "\U00002705 written %s\n", confPath)
+	return nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// createProjectFixture creates a Go project with provided files
+// (path => contents)
+func createProjectFixture(t *testing.T, files map[string]string) string {
+	t.Helper()
+	projectPath := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(projectPath, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return projectPath
+}
+
+func TestBinaryNameFromModule(t *testing.T) {
+	tests := []struct {
+		modPath string
+		want    string
+	}{
+		{"github.com/czcorpus/mquery", "mquery"},
+		{"github.com/czcorpus/mquery/v2", "mquery"},
+		{"github.com/czcorpus/v2", "czcorpus"},
+		{"mquery", "mquery"},
+		{"v2", "v2"},
+		{"github.com/czcorpus/mquery-sru", "mquery-sru"},
+	}
+	for _, tt := range tests {
+		if got := binaryNameFromModule(tt.modPath); got != tt.want {
+			t.Errorf("binaryNameFromModule(%s) = %s, want %s", tt.modPath, got, tt.want)
+		}
+	}
+}
+
+func TestFindCmdDirs(t *testing.T) {
+	projectPath := createProjectFixture(t, map[string]string{
+		"go.mod":              "module example.org/app\n",
+		"cmd/server/main.go":  "package main\n",
+		"cmd/worker/main.go":  "package main\n",
+		"cmd/docs/README.md":  "no Go files here\n",
+		"cmd/stray.go":        "package main\n",
+		"internal/lib/lib.go": "package lib\n",
+	})
+	dirs, err := findCmdDirs(projectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(dirs, ",") != "server,worker" {
+		t.Errorf("unexpected cmd dirs %v", dirs)
+	}
+	dirs, err = findCmdDirs(t.TempDir())
+	if err != nil || len(dirs) != 0 {
+		t.Errorf("expected no cmd dirs in a project without cmd, got %v, %v", dirs, err)
+	}
+}
+
+func TestCreateInitConf(t *testing.T) {
+	libDir := createLibFixture(t, "testdata/libmanatee-2.225.8.so")
+	tests := []struct {
+		name  string
+		files map[string]string
+		check func(t *testing.T, conf initConf)
+	}{
+		{
+			"root package",
+			map[string]string{"go.mod": "module github.com/czcorpus/app/v3\n", "main.go": "package main\n"},
+			func(t *testing.T, conf initConf) {
+				if conf.TargetBinaryName != "app" || conf.CmdDir != "" || len(conf.Targets) != 0 {
+					t.Errorf("unexpected target %v", conf)
+				}
+			},
+		},
+		{
+			"single cmd package",
+			map[string]string{"go.mod": "module example.org/app\n", "cmd/server/main.go": "package main\n"},
+			func(t *testing.T, conf initConf) {
+				if conf.TargetBinaryName != "server" || conf.CmdDir != "server" || len(conf.Targets) != 0 {
+					t.Errorf("unexpected target %v", conf)
+				}
+			},
+		},
+		{
+			"multiple cmd packages",
+			map[string]string{
+				"go.mod":             "module example.org/app\n",
+				"cmd/server/main.go": "package main\n",
+				"cmd/worker/main.go": "package main\n",
+			},
+			func(t *testing.T, conf initConf) {
+				if conf.TargetBinaryName != "" || len(conf.Targets) != 2 ||
+					conf.Targets[0] != (BuildTarget{BinaryName: "server", CmdDir: "server"}) {
+					t.Errorf("unexpected targets %v", conf)
+				}
+			},
+		},
+		{
+			"tests are not enabled automatically",
+			map[string]string{"go.mod": "module example.org/app\n", "main_test.go": "package main\n"},
+			func(t *testing.T, conf initConf) {
+				if conf.RunTests {
+					t.Error("runTests should not be enabled")
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, _, err := createInitConf(createProjectFixture(t, tt.files), libDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conf.ManateeVersion != "2.225.8" || conf.WithPCRE2 {
+				t.Errorf("unexpected Manatee settings %v", conf)
+			}
+			tt.check(t, conf)
+		})
+	}
+	if _, _, err := createInitConf(t.TempDir(), libDir); err == nil {
+		t.Error("expected error for a project without go.mod")
+	}
+}
+
+func TestRunInitCommand(t *testing.T) {
+	libDir := createLibFixture(t, "testdata/libmanatee-2.225.8.so")
+	projectPath := createProjectFixture(t, map[string]string{
+		"go.mod":             "module example.org/app\n",
+		"cmd/server/main.go": "package main\n",
+	})
+	if err := runInitCommand([]string{}, projectPath, libDir); err != nil {
+		t.Fatal(err)
+	}
+	confPath := filepath.Join(projectPath, confFileName)
+	if err := ValidateConfigFile(confPath); err != nil {
+		t.Errorf("generated config should be valid, got %v", err)
+	}
+	if err := runInitCommand([]string{}, projectPath, libDir); err == nil {
+		t.Error("existing config should not be overwritten without -overwrite")
+	}
+	if err := runInitCommand([]string{"-overwrite"}, projectPath, libDir); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
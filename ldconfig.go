@@ -0,0 +1,306 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	ldSoConfPath  = "/etc/ld.so.conf"
+	ldSoCachePath = "/etc/ld.so.cache"
+
+	ldCacheMagicOld    = "ld.so-1.7.0"
+	ldCacheMagicNew    = "glibc-ld.so.cache"
+	ldCacheNewVersion  = "1.1"
+	ldCacheOldEntrySz  = 12
+	ldCacheNewHeaderSz = 48
+	ldCacheNewEntrySz  = 24
+)
+
+var (
+	// trustedLoaderDirs are searched by the dynamic loader
+	// even if they are not listed in ld.so.conf
+	trustedLoaderDirs = []string{"/lib", "/usr/lib", "/lib64", "/usr/lib64"}
+)
+
+// LdCacheEntry is a library record found in ld.so.cache
+type LdCacheEntry struct {
+	Name string
+	Path string
+}
+
+// parseLdSoConf returns library directories listed in a ld.so.conf
+// file including the ones from files referenced via `include`.
+func parseLdSoConf(confPath string) ([]string, error) {
+	ans := make([]string, 0, 10)
+	visited := make(map[string]bool)
+	var parse func(p string) error
+	parse = func(p string) error {
+		if visited[p] {
+			return nil
+		}
+		visited[p] = true
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if i := strings.IndexByte(line, '#'); i >= 0 {
+				line = line[:i]
+			}
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "hwcap ") {
+				continue
+			}
+			if strings.HasPrefix(line, "include ") {
+				for _, ptrn := range strings.Fields(line[len("include "):]) {
+					if !filepath.IsAbs(ptrn) {
+						ptrn = filepath.Join(filepath.Dir(p), ptrn)
+					}
+					matches, err := filepath.Glob(ptrn)
+					if err != nil {
+						return fmt.Errorf("invalid include in %s: %w", p, err)
+					}
+					sort.Strings(matches)
+					for _, m := range matches {
+						if err := parse(m); err != nil && !os.IsNotExist(err) {
+							return err
+						}
+					}
+				}
+				continue
+			}
+			// old syntax allows `dir=TYPE` and comma/space separated lists
+			for _, item := range strings.FieldsFunc(line, func(r rune) bool {
+				return r == ',' || r == ' ' || r == '\t' || r == ':'
+			}) {
+				item, _, _ = strings.Cut(item, "=")
+				ans = append(ans, filepath.Clean(item))
+			}
+		}
+		return scanner.Err()
+	}
+	err := parse(confPath)
+	return ans, err
+}
+
+func readCString(data []byte, offset uint32) string {
+	if int(offset) >= len(data) {
+		return ""
+	}
+	s := data[offset:]
+	if i := bytes.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return string(s)
+}
+
+// parseLdSoCache reads library records from ld.so.cache. Only
+// the "new" (glibc 2.x) format is supported, either standalone
+// or following the old format part.
+func parseLdSoCache(cachePath string) ([]LdCacheEntry, error) {
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		return []LdCacheEntry{}, err
+	}
+	offset := 0
+	if bytes.HasPrefix(data, []byte(ldCacheMagicOld)) {
+		if len(data) < 16 {
+			return []LdCacheEntry{}, fmt.Errorf("truncated %s", cachePath)
+		}
+		nlibs := binary.LittleEndian.Uint32(data[12:16])
+		offset = 16 + int(nlibs)*ldCacheOldEntrySz
+		offset = (offset + 7) &^ 7 // the new part is aligned
+	}
+	if len(data) < offset+ldCacheNewHeaderSz ||
+		!bytes.HasPrefix(data[offset:], []byte(ldCacheMagicNew+ldCacheNewVersion)) {
+		return []LdCacheEntry{}, fmt.Errorf("unsupported format of %s", cachePath)
+	}
+	cache := data[offset:]
+	nlibs := int(binary.LittleEndian.Uint32(cache[20:24]))
+	// the count comes from the file so it cannot be trusted
+	// when allocating memory
+	capacity := nlibs
+	if maxEntries := (len(cache) - ldCacheNewHeaderSz) / ldCacheNewEntrySz; capacity > maxEntries {
+		capacity = maxEntries
+	}
+	ans := make([]LdCacheEntry, 0, capacity)
+	for i := 0; i < nlibs; i++ {
+		entryStart := ldCacheNewHeaderSz + i*ldCacheNewEntrySz
+		if entryStart+ldCacheNewEntrySz > len(cache) {
+			return ans, fmt.Errorf("truncated %s", cachePath)
+		}
+		entry := cache[entryStart : entryStart+ldCacheNewEntrySz]
+		ans = append(ans, LdCacheEntry{
+			Name: readCString(cache, binary.LittleEndian.Uint32(entry[4:8])),
+			Path: readCString(cache, binary.LittleEndian.Uint32(entry[8:12])),
+		})
+	}
+	return ans, nil
+}
+
+// sameDir tests whether two paths refer to the same directory
+// (incl. symlinked ones)
+func sameDir(a, b string) bool {
+	a, b = filepath.Clean(a), filepath.Clean(b)
+	if a == b {
+		return true
+	}
+	ra, errA := filepath.EvalSymlinks(a)
+	rb, errB := filepath.EvalSymlinks(b)
+	return errA == nil && errB == nil && ra == rb
+}
+
+func containsDir(dirs []string, dir string) bool {
+	for _, d := range dirs {
+		if sameDir(d, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsOnDefaultLoaderPath tests whether libmanatee located in dir
+// can be loaded without setting LD_LIBRARY_PATH. Libraries in
+// the trusted directories are always found while libraries
+// in directories listed in ld.so.conf are found only via
+// ld.so.cache (i.e. once ldconfig has been run).
+func IsOnDefaultLoaderPath(dir string) bool {
+	confDirs, _ := parseLdSoConf(ldSoConfPath)
+	cached, _ := parseLdSoCache(ldSoCachePath)
+	return isOnLoaderPath(dir, trustedLoaderDirs, confDirs, cached)
+}
+
+func isOnLoaderPath(dir string, trusted, confDirs []string, cached []LdCacheEntry) bool {
+	if containsDir(trusted, dir) {
+		return true
+	}
+	if !containsDir(confDirs, dir) {
+		return false
+	}
+	for _, entry := range cached {
+		if isManateeLibFile(entry.Name) && sameDir(filepath.Dir(entry.Path), dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// ManateeInstallation describes a libmanatee.so file found
+// in the system
+type ManateeInstallation struct {
+	LibPath string
+	Dir     string
+
+	// Version is a detected version of the library. In case
+	// the detection failed, VersionErr is set.
+	Version    Version
+	VersionErr error
+
+	// Source describes how the library has been found
+	Source string
+
+	// OnDefaultPath specifies whether the dynamic loader finds
+	// the library without LD_LIBRARY_PATH
+	OnDefaultPath bool
+}
+
+// isManateeLibFile matches libmanatee.so and sonamed
+// variants (libmanatee.so.N, libmanatee.so.N.M...)
+func isManateeLibFile(name string) bool {
+	return name == "libmanatee.so" || strings.HasPrefix(name, "libmanatee.so.")
+}
+
+// FindManateeInstallations enumerates all the libmanatee.so files
+// (incl. sonamed ones) available via ld.so.cache, ld.so.conf,
+// LD_LIBRARY_PATH, the trusted loader directories and /opt/manatee.
+func FindManateeInstallations() []ManateeInstallation {
+	type candidate struct {
+		path   string
+		source string
+	}
+	candidates := make([]candidate, 0, 20)
+	addDir := func(dir, source string) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, ent := range entries {
+			if !ent.IsDir() && isManateeLibFile(ent.Name()) {
+				candidates = append(candidates, candidate{filepath.Join(dir, ent.Name()), source})
+			}
+		}
+	}
+	if cached, err := parseLdSoCache(ldSoCachePath); err == nil {
+		for _, entry := range cached {
+			if isManateeLibFile(entry.Name) {
+				candidates = append(candidates, candidate{entry.Path, "ld.so.cache"})
+			}
+		}
+	}
+	for _, dir := range strings.Split(os.Getenv("LD_LIBRARY_PATH"), ":") {
+		if dir != "" {
+			addDir(dir, "LD_LIBRARY_PATH")
+		}
+	}
+	for _, dir := range trustedLoaderDirs {
+		addDir(dir, "default loader path")
+	}
+	if confDirs, err := parseLdSoConf(ldSoConfPath); err == nil {
+		for _, dir := range confDirs {
+			addDir(dir, "ld.so.conf")
+		}
+	}
+	addDir("/usr/local/lib", "/usr/local/lib")
+	if optDirs, err := filepath.Glob("/opt/manatee/*/lib"); err == nil {
+		for _, dir := range optDirs {
+			addDir(dir, "/opt/manatee")
+		}
+	}
+
+	ans := make([]ManateeInstallation, 0, len(candidates))
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		p := filepath.Clean(c.path)
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		inst := ManateeInstallation{
+			LibPath:       p,
+			Dir:           filepath.Dir(p),
+			Source:        c.source,
+			OnDefaultPath: IsOnDefaultLoaderPath(filepath.Dir(p)),
+		}
+		inst.Version, inst.VersionErr = libManateeVersion(p)
+		ans = append(ans, inst)
+	}
+	return ans
+}
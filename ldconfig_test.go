@@ -0,0 +1,141 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLdSoConf(t *testing.T) {
+	dir := t.TempDir()
+	confDir := filepath.Join(dir, "ld.so.conf.d")
+	if err := os.Mkdir(confDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"ld.so.conf":               "# main config\ninclude ld.so.conf.d/*.conf\n/opt/foo/lib\n",
+		"ld.so.conf.d/a.conf":      "/usr/local/lib  # local libs\n\nhwcap 0 nosegneg\n",
+		"ld.so.conf.d/b.conf":      "/opt/bar/lib,/opt/baz/lib=libc6\n",
+		"ld.so.conf.d/ignored.txt": "/opt/ignored\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dirs, err := parseLdSoConf(filepath.Join(dir, "ld.so.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/usr/local/lib", "/opt/bar/lib", "/opt/baz/lib", "/opt/foo/lib"}
+	if len(dirs) != len(want) {
+		t.Fatalf("got %v, want %v", dirs, want)
+	}
+	for i := range want {
+		if dirs[i] != want[i] {
+			t.Errorf("position %d: got %s, want %s", i, dirs[i], want[i])
+		}
+	}
+}
+
+func TestParseLdSoCache(t *testing.T) {
+	strs := []string{"libmanatee.so.3", "/opt/manatee/2.225.8/lib/libmanatee.so.3"}
+	header := make([]byte, ldCacheNewHeaderSz)
+	copy(header, ldCacheMagicNew+ldCacheNewVersion)
+	binary.LittleEndian.PutUint32(header[20:24], 1)
+	entry := make([]byte, ldCacheNewEntrySz)
+	strTable := ldCacheNewHeaderSz + ldCacheNewEntrySz
+	binary.LittleEndian.PutUint32(entry[4:8], uint32(strTable))
+	binary.LittleEndian.PutUint32(entry[8:12], uint32(strTable+len(strs[0])+1))
+	data := append(header, entry...)
+	for _, s := range strs {
+		data = append(data, append([]byte(s), 0)...)
+	}
+	cachePath := filepath.Join(t.TempDir(), "ld.so.cache")
+	if err := os.WriteFile(cachePath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := parseLdSoCache(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != strs[0] || entries[0].Path != strs[1] {
+		t.Errorf("unexpected entries %v", entries)
+	}
+	if !isManateeLibFile(entries[0].Name) {
+		t.Errorf("%s should be recognized as libmanatee", entries[0].Name)
+	}
+}
+
+func TestParseLdSoCacheCorruptedCount(t *testing.T) {
+	header := make([]byte, ldCacheNewHeaderSz)
+	copy(header, ldCacheMagicNew+ldCacheNewVersion)
+	binary.LittleEndian.PutUint32(header[20:24], 0xffffffff)
+	data := append(header, make([]byte, ldCacheNewEntrySz)...)
+	cachePath := filepath.Join(t.TempDir(), "ld.so.cache")
+	if err := os.WriteFile(cachePath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := parseLdSoCache(cachePath)
+	if err == nil {
+		t.Error("expected error for a truncated cache")
+	}
+	if len(entries) != 1 || cap(entries) != 1 {
+		t.Errorf("expected a single entry, got %d (capacity %d)", len(entries), cap(entries))
+	}
+}
+
+func TestParseLdSoCacheInvalid(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "ld.so.cache")
+	if err := os.WriteFile(cachePath, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseLdSoCache(cachePath); err == nil {
+		t.Error("expected error for an unsupported cache format")
+	}
+}
+
+func TestIsOnLoaderPath(t *testing.T) {
+	trusted := []string{"/lib", "/usr/lib"}
+	confDirs := []string{"/usr/local/lib", "/opt/manatee/lib"}
+	cached := []LdCacheEntry{
+		{Name: "libmanatee.so.3", Path: "/usr/local/lib/libmanatee.so.3"},
+		{Name: "libc.so.6", Path: "/opt/manatee/lib/libc.so.6"},
+	}
+	tests := []struct {
+		dir  string
+		want bool
+	}{
+		{"/usr/lib", true},
+		{"/usr/lib/", true},
+		{"/usr/local/lib", true},
+		// listed in ld.so.conf but libmanatee is not in ld.so.cache
+		// (i.e. ldconfig has not been run)
+		{"/opt/manatee/lib", false},
+		{"/home/me/lib", false},
+	}
+	for _, tt := range tests {
+		if got := isOnLoaderPath(tt.dir, trusted, confDirs, cached); got != tt.want {
+			t.Errorf("isOnLoaderPath(%s) = %v, want %v", tt.dir, got, tt.want)
+		}
+	}
+}
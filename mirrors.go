@@ -0,0 +1,160 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// expandMirrorTemplate replaces placeholders in a mirror URL template:
+//
+//   - {version} - numeric version (e.g. 2.225.8)
+//   - {variant} - variant suffix including a leading dash (e.g. -cnc),
+//     empty for upstream manatee-open
+//
+// E.g. https://mirror/manatee-open-{version}{variant}.tar.gz
+func expandMirrorTemplate(tpl string, ver Version) string {
+	var variant string
+	if ver.Variant != "" {
+		variant = "-" + ver.Variant
+	}
+	return strings.NewReplacer("{version}", ver.Semver(), "{variant}", variant).Replace(tpl)
+}
+
+func isRemoteMirror(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// localMirrorPath returns a filesystem path of a file:// URL or
+// a plain path. Returns false in case the location is not local.
+func localMirrorPath(location string) (string, bool) {
+	if strings.HasPrefix(location, "file://") {
+		u, err := url.Parse(location)
+		if err != nil || u.Path == "" {
+			return "", false
+		}
+		return u.Path, true
+	}
+	if strings.Contains(location, "://") {
+		return "", false
+	}
+	return location, true
+}
+
+// resolveMirrorConfPath resolves relative local mirrors against
+// the config file location.
+func resolveMirrorConfPath(confDir, location string) string {
+	if p, ok := localMirrorPath(location); ok && !strings.HasPrefix(location, "file://") {
+		return resolveConfPath(confDir, p)
+	}
+	return location
+}
+
+// copyLocalArchive copies an archive from a local mirror. Like
+// with downloads, the target file is written only once complete.
+func copyLocalArchive(srcPath, target string, progress progressFunc) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	var total int64 = -1
+	if info, err := src.Stat(); err == nil {
+		total = info.Size()
+	}
+	partPath := target + downloadPartSuffix
+	outf, err := os.Create(partPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(outf, &countingReader{r: src, total: total, progress: progress})
+	if cerr := outf.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return err
+	}
+	return os.Rename(partPath, target)
+}
+
+// fetchFromMirror obtains a source archive of a specified version
+// from a mirror. A local mirror may be either a path of the archive
+// or a directory containing it (under its standard name).
+func fetchFromMirror(ctx context.Context, dl *Downloader, tpl string, ver Version, target string) error {
+	location := expandMirrorTemplate(tpl, ver)
+	if isRemoteMirror(location) {
+		return dl.Download(ctx, location, target)
+	}
+	localPath, ok := localMirrorPath(location)
+	if !ok {
+		return fmt.Errorf("unsupported mirror %s", location)
+	}
+	if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+		localPath = filepath.Join(localPath, manateeArchiveName(ver))
+	}
+	if err := copyLocalArchive(localPath, target, dl.Progress); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", localPath, err)
+	}
+	return nil
+}
+
+// mirrorsFor returns mirror templates applicable to a specified version.
+// Configured mirrors replace the default ones of the respective variant.
+func mirrorsFor(ver Version, configured []string) []string {
+	if len(configured) > 0 {
+		return configured
+	}
+	return ver.ManateeVariant().DownloadURLs
+}
+
+// removePartFiles removes unfinished downloads of target
+// from all the mirrors
+func removePartFiles(target string) {
+	parts, _ := filepath.Glob(target + "*" + downloadPartSuffix)
+	for _, p := range parts {
+		os.Remove(p)
+	}
+}
+
+// fetchManateeArchive tries the mirrors in the specified order
+// until the archive is obtained. Unfinished downloads are resumed
+// only from the mirror they come from.
+func fetchManateeArchive(ctx context.Context, dl *Downloader, ver Version, mirrors []string, target string) error {
+	if len(mirrors) == 0 {
+		return fmt.Errorf("no download location known for %s", ver.ManateeVariant().Description)
+	}
+	errs := make([]error, 0, len(mirrors))
+	for _, tpl := range mirrors {
+		err := fetchFromMirror(ctx, dl, tpl, ver, target)
+		if err == nil {
+			removePartFiles(target)
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("all mirrors failed: %w", errors.Join(errs...))
+}
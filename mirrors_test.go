@@ -0,0 +1,140 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestExpandMirrorTemplate(t *testing.T) {
+	tpl := "https://mirror/{version}/manatee-open-{version}{variant}.tar.gz"
+	tests := []struct {
+		ver  Version
+		want string
+	}{
+		{Version{2, 225, 8, ""}, "https://mirror/2.225.8/manatee-open-2.225.8.tar.gz"},
+		{Version{2, 225, 8, "cnc"}, "https://mirror/2.225.8/manatee-open-2.225.8-cnc.tar.gz"},
+	}
+	for _, tt := range tests {
+		if got := expandMirrorTemplate(tpl, tt.ver); got != tt.want {
+			t.Errorf("expandMirrorTemplate(%s) = %s, want %s", tt.ver, got, tt.want)
+		}
+	}
+}
+
+func TestFetchManateeArchive(t *testing.T) {
+	ver := Version{2, 225, 8, "cnc"}
+	mirrorDir := t.TempDir()
+	archiveName := "manatee-open-2.225.8-cnc.tar.gz"
+	if err := os.WriteFile(filepath.Join(mirrorDir, archiveName), []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+archiveName {
+			w.Write([]byte("remote"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		mirrors []string
+		want    string
+	}{
+		{"remote", []string{srv.URL + "/missing-{version}.tar.gz", srv.URL + "/manatee-open-{version}{variant}.tar.gz"}, "remote"},
+		{"file URL", []string{"file://" + mirrorDir + "/manatee-open-{version}{variant}.tar.gz"}, "local"},
+		{"local directory", []string{filepath.Join(mirrorDir, "missing"), mirrorDir}, "local"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), archiveName)
+			if err := fetchManateeArchive(context.Background(), testDownloader(), ver, tt.mirrors, target); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("got %s, want %s", data, tt.want)
+			}
+		})
+	}
+
+	target := filepath.Join(t.TempDir(), archiveName)
+	if err := fetchManateeArchive(context.Background(), testDownloader(), ver, []string{srv.URL + "/missing.tar.gz"}, target); err == nil {
+		t.Error("expected error in case all mirrors fail")
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Error("failed download should not leave the target file")
+	}
+}
+
+func TestFetchManateeArchiveDoesNotMixMirrors(t *testing.T) {
+	ver := Version{2, 225, 8, ""}
+	// the first mirror sends a half of a different file and then fails
+	var truncated int32
+	srvA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !atomic.CompareAndSwapInt32(&truncated, 0, 1) {
+			http.NotFound(w, r)
+			return
+		}
+		other := strings.Repeat("x", len(testPayload))
+		w.Header().Set("Content-Length", strconv.Itoa(len(other)))
+		w.Write([]byte(other[:len(other)/2]))
+		w.(http.Flusher).Flush()
+		if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+			conn.Close()
+		}
+	}))
+	defer srvA.Close()
+	handler, _ := rangeHandler(0, nil)
+	srvB := httptest.NewServer(handler)
+	defer srvB.Close()
+
+	target := filepath.Join(t.TempDir(), manateeArchiveName(ver))
+	mirrors := []string{srvA.URL + "/manatee-open-{version}.tar.gz", srvB.URL + "/manatee-open-{version}.tar.gz"}
+	if err := fetchManateeArchive(context.Background(), testDownloader(), ver, mirrors, target); err != nil {
+		t.Fatal(err)
+	}
+	checkDownloaded(t, target)
+}
+
+func TestMirrorsFor(t *testing.T) {
+	cnc := Version{2, 225, 8, "cnc"}
+	if m := mirrorsFor(cnc, []string{}); len(m) != 0 {
+		t.Errorf("expected no default mirrors for the cnc variant, got %v", m)
+	}
+	if m := mirrorsFor(cnc, []string{"/srv/manatee"}); len(m) != 1 {
+		t.Errorf("expected configured mirrors, got %v", m)
+	}
+	if m := mirrorsFor(Version{2, 225, 8, ""}, []string{}); len(m) == 0 {
+		t.Error("expected default mirrors for upstream Manatee")
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	recipeSourceBuiltin = "built-in"
+)
+
+//go:embed recipes.json
+var builtinRecipesData []byte
+
+// LinkLib is a library the built project is linked against
+type LinkLib struct {
+	Name string `json:"name" desc:"Library name as passed to -l"`
+
+	// Dir is a directory containing the library. An empty value
+	// stands for the Manatee library directory, a relative path
+	// is resolved against the Manatee sources.
+	Dir string `json:"dir,omitempty" desc:"Library directory (empty = Manatee lib dir, relative = within Manatee sources)"`
+}
+
+// BuildRecipe describes how to configure Manatee sources
+// and how to build a project against a range of Manatee versions.
+// All the directories are relative to the Manatee sources.
+type BuildRecipe struct {
+	Versions string `json:"versions" desc:"Version constraint the recipe applies to (e.g. >=2.208)"`
+
+	// IncludeDirs are passed to CGO_CPPFLAGS ("." stands for
+	// the root of the sources)
+	IncludeDirs []string `json:"includeDirs" desc:"Include subdirectories for CGO_CPPFLAGS"`
+
+	// CxxIncludeDirs are passed to CGO_CXXFLAGS
+	CxxIncludeDirs []string `json:"cxxIncludeDirs" desc:"Include subdirectories for CGO_CXXFLAGS"`
+
+	// AuxLibs are subdirectories where `make` is run once
+	// the sources are configured
+	AuxLibs []string `json:"auxLibs" desc:"Subdirectories with auxiliary libraries to be built"`
+
+	LinkLibs []LinkLib `json:"linkLibs" desc:"Libraries to link against"`
+
+	CxxStd string `json:"cxxStd" desc:"C++ standard (e.g. c++14)"`
+
+	// ConfigureFlags are passed to `./configure` along with the PCRE
+	// flag and flags required by the Manatee variant
+	ConfigureFlags []string `json:"configureFlags" desc:"Flags passed to the configure script"`
+
+	constraint VersionConstraint
+	source     string
+}
+
+// Source describes where the recipe has been defined
+func (r BuildRecipe) Source() string {
+	return r.source
+}
+
+// CPPFlags returns a value of CGO_CPPFLAGS
+func (r BuildRecipe) CPPFlags(manateeSrc string) string {
+	ans := make([]string, len(r.IncludeDirs))
+	for i, d := range r.IncludeDirs {
+		ans[i] = "-I" + filepath.Join(manateeSrc, d)
+	}
+	return strings.Join(ans, " ")
+}
+
+// CXXFlags returns a value of CGO_CXXFLAGS
+func (r BuildRecipe) CXXFlags(manateeSrc string) string {
+	ans := make([]string, 0, len(r.CxxIncludeDirs)+1)
+	if r.CxxStd != "" {
+		ans = append(ans, "-std="+r.CxxStd)
+	}
+	for _, d := range r.CxxIncludeDirs {
+		ans = append(ans, "-I"+filepath.Join(manateeSrc, d))
+	}
+	return strings.Join(ans, " ")
+}
+
+// LDFlags returns a value of CGO_LDFLAGS
+func (r BuildRecipe) LDFlags(manateeSrc, manateeLib string) string {
+	ans := make([]string, 0, 2*len(r.LinkLibs))
+	for _, lib := range r.LinkLibs {
+		dir := lib.Dir
+		if dir == "" {
+			dir = manateeLib
+
+		} else if !filepath.IsAbs(dir) {
+			dir = filepath.Join(manateeSrc, dir)
+		}
+		ans = append(ans, "-l"+lib.Name, "-L"+dir)
+	}
+	return strings.Join(ans, " ")
+}
+
+func (r *BuildRecipe) init(source string) error {
+	var err error
+	r.constraint, err = ParseVersionConstraint(r.Versions)
+	if err != nil {
+		return fmt.Errorf("invalid recipe versions: %w", err)
+	}
+	for _, lib := range r.LinkLibs {
+		if lib.Name == "" {
+			return fmt.Errorf("recipe for %s: missing link library name", r.Versions)
+		}
+	}
+	r.source = source
+	return nil
+}
+
+// RecipeRegistry contains build recipes ordered by priority
+type RecipeRegistry struct {
+	recipes []BuildRecipe
+}
+
+type recipesFile struct {
+	Recipes []BuildRecipe `json:"recipes"`
+}
+
+// add appends recipes with lower priority than the existing ones
+func (rr *RecipeRegistry) add(recipes []BuildRecipe, source string) error {
+	errs := make([]error, 0, len(recipes))
+	for i := range recipes {
+		if err := recipes[i].init(source); err != nil {
+			errs = append(errs, fmt.Errorf("recipes[%d]: %w", i, err))
+			continue
+		}
+		rr.recipes = append(rr.recipes, recipes[i])
+	}
+	return errors.Join(errs...)
+}
+
+// Find returns the first recipe matching the version
+func (rr *RecipeRegistry) Find(ver Version) (BuildRecipe, error) {
+	for _, r := range rr.recipes {
+		if r.constraint.Check(ver) {
+			return r, nil
+		}
+	}
+	return BuildRecipe{}, fmt.Errorf("no build recipe found for Manatee %s", ver.Full())
+}
+
+// Recipes returns all the recipes ordered by priority
+func (rr *RecipeRegistry) Recipes() []BuildRecipe {
+	return rr.recipes
+}
+
+func loadRecipesFile(path string) ([]BuildRecipe, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []BuildRecipe{}, fmt.Errorf("failed to load recipes %s: %w", path, err)
+	}
+	var rf recipesFile
+	if err := decodeStrict(data, &rf); err != nil {
+		return []BuildRecipe{}, fmt.Errorf("failed to load recipes %s: %w", path, err)
+	}
+	return rf.Recipes, nil
+}
+
+// LoadRecipes creates a registry of build recipes. Recipes defined
+// directly in the configuration take precedence over the ones from
+// the configured recipes file which in turn take precedence over
+// the built-in ones.
+func LoadRecipes(conf *Conf) (*RecipeRegistry, error) {
+	ans := &RecipeRegistry{}
+	if len(conf.Recipes) > 0 {
+		recipes := make([]BuildRecipe, len(conf.Recipes))
+		copy(recipes, conf.Recipes)
+		if err := ans.add(recipes, conf.Origin("recipes")); err != nil {
+			return ans, err
+		}
+	}
+	if conf.RecipesFile != "" {
+		recipes, err := loadRecipesFile(conf.RecipesFile)
+		if err != nil {
+			return ans, err
+		}
+		if err := ans.add(recipes, "recipes file "+conf.RecipesFile); err != nil {
+			return ans, fmt.Errorf("invalid recipes file %s: %w", conf.RecipesFile, err)
+		}
+	}
+	var builtin recipesFile
+	if err := decodeStrict(builtinRecipesData, &builtin); err != nil {
+		return ans, fmt.Errorf("failed to load built-in recipes: %w", err)
+	}
+	if err := ans.add(builtin.Recipes, recipeSourceBuiltin); err != nil {
+		return ans, fmt.Errorf("invalid built-in recipes: %w", err)
+	}
+	return ans, nil
+}
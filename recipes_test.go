@@ -0,0 +1,117 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func findRecipe(t *testing.T, conf *Conf, ver string) BuildRecipe {
+	t.Helper()
+	recipes, err := LoadRecipes(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := ParseManateeVersion(ver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipe, err := recipes.Find(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recipe
+}
+
+func TestBuiltinRecipeFlags(t *testing.T) {
+	tests := []struct {
+		version  string
+		auxLibs  int
+		cppFlags string
+		cxxFlags string
+		ldFlags  string
+	}{
+		{
+			"2.208",
+			2,
+			"-I/src -I/src/finlib -I/src/fsa3 -I/src/hat-trie",
+			"-std=c++14 -I/src/corp -I/src/concord -I/src/query",
+			"-lmanatee -L/lib -lhat-trie -L/lib -lfsa3 -L/src/fsa3/.libs",
+		},
+		{
+			"2.167.10",
+			0,
+			"-I/src",
+			"-std=c++14 -I/src/corp -I/src/concord -I/src/query",
+			"-lmanatee -L/lib",
+		},
+	}
+	for _, tt := range tests {
+		recipe := findRecipe(t, NewConf(), tt.version)
+		if recipe.Source() != recipeSourceBuiltin {
+			t.Errorf("%s: expected built-in recipe, got %s", tt.version, recipe.Source())
+		}
+		if len(recipe.AuxLibs) != tt.auxLibs {
+			t.Errorf("%s: expected %d aux. libraries, got %v", tt.version, tt.auxLibs, recipe.AuxLibs)
+		}
+		if got := recipe.CPPFlags("/src"); got != tt.cppFlags {
+			t.Errorf("%s: CPPFlags() = %s, want %s", tt.version, got, tt.cppFlags)
+		}
+		if got := recipe.CXXFlags("/src"); got != tt.cxxFlags {
+			t.Errorf("%s: CXXFlags() = %s, want %s", tt.version, got, tt.cxxFlags)
+		}
+		if got := recipe.LDFlags("/src", "/lib"); got != tt.ldFlags {
+			t.Errorf("%s: LDFlags() = %s, want %s", tt.version, got, tt.ldFlags)
+		}
+	}
+}
+
+func TestRecipeOverrides(t *testing.T) {
+	recipesPath := filepath.Join(t.TempDir(), "recipes.json")
+	data := `{"recipes": [{"versions": ">=2.225", "cxxStd": "c++17"}, {"versions": "~2.223", "cxxStd": "c++11"}]}`
+	if err := os.WriteFile(recipesPath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := NewConf()
+	conf.RecipesFile = recipesPath
+	conf.Recipes = []BuildRecipe{{Versions: "2.225.8", CxxStd: "c++20"}}
+
+	if r := findRecipe(t, conf, "2.225.8"); r.CxxStd != "c++20" {
+		t.Errorf("config recipe should take precedence, got %s (%s)", r.CxxStd, r.Source())
+	}
+	if r := findRecipe(t, conf, "2.226.0"); r.CxxStd != "c++17" {
+		t.Errorf("recipes file should take precedence, got %s (%s)", r.CxxStd, r.Source())
+	}
+	if r := findRecipe(t, conf, "2.214.1"); r.Source() != recipeSourceBuiltin {
+		t.Errorf("expected built-in recipe, got %s", r.Source())
+	}
+}
+
+func TestInvalidRecipe(t *testing.T) {
+	conf := NewConf()
+	conf.Recipes = []BuildRecipe{{Versions: ">=foo"}}
+	if _, err := LoadRecipes(conf); err == nil {
+		t.Error("expected error for an invalid recipe version constraint")
+	}
+	if err := conf.Validate(); err == nil {
+		t.Error("expected validation error for an invalid recipe")
+	}
+}
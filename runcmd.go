@@ -101,11 +101,13 @@ func RunCommand(cmd *exec.Cmd, opts ...RunCommandOption) error {
 }
 
 type OperationSequence struct {
-	sp       *spinner.Spinner
-	currIdx  int
-	mtx      *sync.Mutex
-	loc      *time.Location
-	finished bool
+	sp           *spinner.Spinner
+	currIdx      int
+	currTitle    string
+	mtx          *sync.Mutex
+	loc          *time.Location
+	finished     bool
+	failHandlers []func(operation string)
 }
 
 func (seq *OperationSequence) TimeLocation() *time.Location {
@@ -115,7 +117,7 @@ func (seq *OperationSequence) TimeLocation() *time.Location {
 func (seq *OperationSequence) WithPausedOutput(fn func()) {
 	seq.mtx.Lock()
 	defer seq.mtx.Unlock()
-	if seq.sp.Active() {
+	if seq.sp != nil && seq.sp.Active() {
 		seq.sp.Stop()
 		fmt.Fprint(os.Stderr, "")
 		fn()
@@ -132,14 +134,40 @@ func (seq *OperationSequence) Fail(fn func()) {
 		fmt.Fprint(os.Stderr, "")
 	}
 	fn()
+	for _, handler := range seq.failHandlers {
+		handler(seq.currTitle)
+	}
 	os.Exit(1) // deferred functions are not run !!!
 }
 
+// Progress returns a progressFunc displaying a progress
+// of the current operation next to the spinner.
+func (seq *OperationSequence) Progress(label string) progressFunc {
+	if seq == nil {
+		return nil
+	}
+	return func(done, total int64) {
+		if seq.sp == nil {
+			return
+		}
+		seq.sp.Lock()
+		seq.sp.Suffix = fmt.Sprintf(" %s %s", label, formatProgress(done, total))
+		seq.sp.Unlock()
+	}
+}
+
+// OnFail registers a function called with a title of a failed
+// operation right before the process exits (see Fail).
+func (seq *OperationSequence) OnFail(fn func(operation string)) {
+	seq.failHandlers = append(seq.failHandlers, fn)
+}
+
 func (seq *OperationSequence) RunOperation(title string, fn func(sq *OperationSequence)) {
 	if seq.finished {
 		panic("operation sequence already finished")
 	}
 	seq.currIdx++
+	seq.currTitle = title
 	color.New(color.FgCyan).Fprintf(os.Stderr, "\n=== [%d] %s ===\n", seq.currIdx, title)
 
 	seq.sp = spinner.New(
@@ -0,0 +1,76 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+)
+
+const (
+	jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+)
+
+// typeSchema generates a JSON Schema describing a Go type
+// based on its `json` and `desc` struct tags.
+func typeSchema(tp reflect.Type) map[string]any {
+	switch tp.Kind() {
+	case reflect.Pointer:
+		return typeSchema(tp.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return map[string]any{"type": "integer"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(tp.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(tp.Elem())}
+	case reflect.Struct:
+		props := make(map[string]any)
+		for i := 0; i < tp.NumField(); i++ {
+			field := tp.Field(i)
+			key := strings.Split(field.Tag.Get("json"), ",")[0]
+			if !field.IsExported() || key == "" || key == "-" {
+				continue
+			}
+			fs := typeSchema(field.Type)
+			if desc := field.Tag.Get("desc"); desc != "" {
+				fs["description"] = desc
+			}
+			props[key] = fs
+		}
+		return map[string]any{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+	default:
+		return map[string]any{}
+	}
+}
+
+// ConfJSONSchema returns a JSON Schema of the .manabuild.json file
+func ConfJSONSchema() map[string]any {
+	ans := typeSchema(reflect.TypeOf(Conf{}))
+	ans["$schema"] = jsonSchemaDraft
+	ans["title"] = "manabuild configuration"
+	return ans
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfJSONSchema(t *testing.T) {
+	schema := ConfJSONSchema()
+	if schema["$schema"] != jsonSchemaDraft {
+		t.Errorf("unexpected $schema %v", schema["$schema"])
+	}
+	if schema["additionalProperties"] != false {
+		t.Error("unknown keys should not be allowed")
+	}
+	props, ok := schema["properties"].(map[string]any)
+	if !ok {
+		t.Fatalf("missing properties")
+	}
+	tp := reflect.TypeOf(Conf{})
+	for i := 0; i < tp.NumField(); i++ {
+		field := tp.Field(i)
+		key := strings.Split(field.Tag.Get("json"), ",")[0]
+		if !field.IsExported() || key == "" || key == "-" {
+			continue
+		}
+		if _, ok := props[key]; !ok {
+			t.Errorf("missing property %s", key)
+		}
+	}
+	if _, ok := props["$schema"]; !ok {
+		t.Error("$schema reference should be allowed in config files")
+	}
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"runTests", "boolean"},
+		{"downloadRetries", "integer"},
+		{"manateeVersion", "string"},
+		{"mirrors", "array"},
+		{"checksums", "object"},
+		{"targets", "array"},
+	}
+	for _, tt := range tests {
+		prop := props[tt.key].(map[string]any)
+		if prop["type"] != tt.want {
+			t.Errorf("unexpected type of %s: %v, want %s", tt.key, prop["type"], tt.want)
+		}
+		if desc, _ := prop["description"].(string); desc == "" {
+			t.Errorf("missing description of %s", tt.key)
+		}
+	}
+	targetProps := props["targets"].(map[string]any)["items"].(map[string]any)["properties"].(map[string]any)
+	if _, ok := targetProps["binaryName"]; !ok {
+		t.Error("target items should describe binaryName")
+	}
+	if _, err := json.Marshal(schema); err != nil {
+		t.Errorf("schema should be serializable: %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultSrcLockTimeout = 30 * time.Minute
+
+	srcLockFileName     = ".manabuild.lock"
+	srcLockPollInterval = 500 * time.Millisecond
+)
+
+var (
+	ErrLockTimeout = errors.New("timeout waiting for lock")
+)
+
+// FileLock is an exclusive advisory lock (flock) shared among
+// processes. The lock file contains PID of the holding process.
+type FileLock struct {
+	f *os.File
+}
+
+// lockHolderPID returns PID written to a lock file or 0
+// in case it is not known (yet).
+func lockHolderPID(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// tryFileLock tries to obtain an exclusive lock of the file (which
+// is created if needed) without waiting. In case the lock is held
+// by another process, nil is returned along with a nil error.
+func tryFileLock(path string) (*FileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %w", path, err)
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		f.Close()
+		return nil, nil
+
+	} else if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &FileLock{f: f}, nil
+}
+
+// AcquireFileLock obtains an exclusive lock of the file (which is
+// created if needed). In case the lock is held by another process,
+// onWait is called with the holder's PID (0 if unknown) and the
+// lock is retried until it is released or the timeout is reached.
+// A zero timeout means waiting without any limit.
+func AcquireFileLock(path string, timeout time.Duration, onWait func(pid int)) (*FileLock, error) {
+	deadline := time.Now().Add(timeout)
+	lastPID := -1
+	for {
+		lock, err := tryFileLock(path)
+		if err != nil || lock != nil {
+			return lock, err
+		}
+		pid := lockHolderPID(path)
+		if pid != lastPID && onWait != nil {
+			onWait(pid)
+		}
+		lastPID = pid
+		if timeout > 0 && time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w %s held by PID %d (waited %s)", ErrLockTimeout, path, pid, timeout)
+		}
+		time.Sleep(srcLockPollInterval)
+	}
+}
+
+// Release unlocks the lock. Please note that the lock is also
+// released by the OS once the holding process exits.
+func (lock *FileLock) Release() error {
+	lock.f.Truncate(0)
+	if err := syscall.Flock(int(lock.f.Fd()), syscall.LOCK_UN); err != nil {
+		lock.f.Close()
+		return fmt.Errorf("failed to unlock %s: %w", lock.f.Name(), err)
+	}
+	return lock.f.Close()
+}
+
+// manateeSrcLockPath returns a lock file guarding a Manatee source
+// tree. For a user-provided tree, the lock file is placed into the
+// tree itself. For a cached tree, it is placed next to the tree
+// so it can be used before the tree is downloaded and extracted.
+func manateeSrcLockPath(cacheDir string, ver Version, manateeSrc string) string {
+	if manateeSrc != "" {
+		return filepath.Join(manateeSrc, srcLockFileName)
+	}
+	return filepath.Join(cacheDir, "."+manateeSrcDirName(ver)+".lock")
+}
+
+// lockManateeSrc obtains an exclusive lock of a Manatee source tree
+// so parallel runs do not download, extract or configure the same
+// tree at the same time. A waiting run reports the holder's PID.
+func lockManateeSrc(
+	seq *OperationSequence,
+	cacheDir string,
+	ver Version,
+	manateeSrc string,
+	timeout time.Duration,
+) (*FileLock, error) {
+	lockPath := manateeSrcLockPath(cacheDir, ver, manateeSrc)
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory for lock %s: %w", lockPath, err)
+	}
+	return AcquireFileLock(lockPath, timeout, func(pid int) {
+		seq.WithPausedOutput(func() {
+			if pid > 0 {
+				fmt.Fprintf(os.Stderr, "waiting for lock held by PID %d (%s)\n", pid, lockPath)
+
+			} else {
+				fmt.Fprintf(os.Stderr, "waiting for lock held by another process (%s)\n", lockPath)
+			}
+		})
+	})
+}
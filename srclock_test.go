@@ -0,0 +1,113 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireFileLock(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), "test.lock")
+	lock, err := AcquireFileLock(lockPath, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid := lockHolderPID(lockPath); pid != os.Getpid() {
+		t.Errorf("expected holder PID %d, got %d", os.Getpid(), pid)
+	}
+
+	// flock locks are bound to open files so even the same process
+	// has to wait for the lock here
+	var waitingFor []int
+	_, err = AcquireFileLock(lockPath, 100*time.Millisecond, func(pid int) {
+		waitingFor = append(waitingFor, pid)
+	})
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("expected ErrLockTimeout, got %v", err)
+	}
+	if len(waitingFor) != 1 || waitingFor[0] != os.Getpid() {
+		t.Errorf("expected a single wait notification with PID %d, got %v", os.Getpid(), waitingFor)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if pid := lockHolderPID(lockPath); pid != 0 {
+		t.Errorf("expected no holder PID after release, got %d", pid)
+	}
+	lock, err = AcquireFileLock(lockPath, 100*time.Millisecond, func(pid int) {
+		t.Error("unexpected wait for a released lock")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.Release()
+}
+
+func TestAcquireFileLockWaitsForRelease(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), "test.lock")
+	lock, err := AcquireFileLock(lockPath, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		lock.Release()
+	}()
+	waited := false
+	lock2, err := AcquireFileLock(lockPath, 5*time.Second, func(pid int) {
+		waited = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock2.Release()
+	if !waited {
+		t.Error("expected a wait notification")
+	}
+}
+
+func TestManateeSrcLockPath(t *testing.T) {
+	ver := Version{2, 225, 8, "cnc"}
+	if got := manateeSrcLockPath("/cache", ver, ""); got != "/cache/.manatee-open-2.225.8-cnc.lock" {
+		t.Errorf("unexpected lock path for a cached tree: %s", got)
+	}
+	if got := manateeSrcLockPath("/cache", ver, "/src/manatee"); got != "/src/manatee/.manabuild.lock" {
+		t.Errorf("unexpected lock path for a provided tree: %s", got)
+	}
+}
+
+func TestLockFileNotListedInCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	lockPath := manateeSrcLockPath(cacheDir, Version{2, 225, 8, ""}, "")
+	if err := os.WriteFile(lockPath, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := listCacheEntries(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no cache entries, got %v", entries)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/czcorpus/cnc-gokit/fs"
+)
+
+const (
+	srcTreeManifestFileName = ".manabuild-complete.json"
+)
+
+var (
+	ErrIncompleteSrcTree = errors.New("incomplete source tree")
+)
+
+// srcTreeManifest is written to an unpacked source tree once
+// the extraction is complete. A tree without a valid manifest
+// is not reused.
+type srcTreeManifest struct {
+	Version       string    `json:"version"`
+	ArchiveSHA256 string    `json:"archiveSha256"`
+	Dirs          []string  `json:"dirs"`
+	Completed     time.Time `json:"completed"`
+}
+
+// ExpectedDirs returns subdirectories of Manatee sources
+// the recipe depends on
+func (r BuildRecipe) ExpectedDirs() []string {
+	ans := make([]string, 0, len(r.IncludeDirs)+len(r.CxxIncludeDirs)+len(r.AuxLibs))
+	seen := make(map[string]bool)
+	for _, group := range [][]string{r.IncludeDirs, r.CxxIncludeDirs, r.AuxLibs} {
+		for _, d := range group {
+			d = filepath.Clean(d)
+			if d == "." || seen[d] {
+				continue
+			}
+			seen[d] = true
+			ans = append(ans, d)
+		}
+	}
+	return ans
+}
+
+func writeSrcTreeManifest(srcDir string, manifest srcTreeManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	manifestPath := filepath.Join(srcDir, srcTreeManifestFileName)
+	if err := os.WriteFile(manifestPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", manifestPath, err)
+	}
+	return nil
+}
+
+// markSrcTreeComplete writes a completion manifest
+// to a freshly unpacked source tree
+func markSrcTreeComplete(srcDir string, ver Version, recipe BuildRecipe, archivePath string) error {
+	sum, err := fileSHA256(archivePath)
+	if err != nil {
+		return err
+	}
+	return writeSrcTreeManifest(srcDir, srcTreeManifest{
+		Version:       ver.Full(),
+		ArchiveSHA256: sum,
+		Dirs:          recipe.ExpectedDirs(),
+		Completed:     time.Now(),
+	})
+}
+
+// validateSrcTree checks whether an unpacked source tree is complete
+// and matches the required version, the recipe and (if still cached)
+// the source archive. ErrIncompleteSrcTree is returned otherwise.
+func validateSrcTree(srcDir string, ver Version, recipe BuildRecipe, archivePath string) error {
+	data, err := os.ReadFile(filepath.Join(srcDir, srcTreeManifestFileName))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: missing completion manifest", ErrIncompleteSrcTree)
+
+	} else if err != nil {
+		return err
+	}
+	var manifest srcTreeManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("%w: invalid completion manifest: %s", ErrIncompleteSrcTree, err)
+	}
+	if manifest.Version != ver.Full() {
+		return fmt.Errorf(
+			"%w: unpacked version %s, required %s", ErrIncompleteSrcTree, manifest.Version, ver.Full())
+	}
+	for _, d := range recipe.ExpectedDirs() {
+		if isDir, _ := fs.IsDir(filepath.Join(srcDir, d)); !isDir {
+			return fmt.Errorf("%w: missing directory %s", ErrIncompleteSrcTree, d)
+		}
+	}
+	if fs.PathExists(archivePath) {
+		sum, err := fileSHA256(archivePath)
+		if err != nil {
+			return err
+		}
+		if sum != manifest.ArchiveSHA256 {
+			return fmt.Errorf("%w: unpacked from a different archive", ErrIncompleteSrcTree)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildRecipeExpectedDirs(t *testing.T) {
+	recipe := BuildRecipe{
+		IncludeDirs:    []string{".", "finlib", "fsa3", "hat-trie"},
+		CxxIncludeDirs: []string{"corp", "concord", "query"},
+		AuxLibs:        []string{"hat-trie", "fsa3"},
+	}
+	want := []string{"finlib", "fsa3", "hat-trie", "corp", "concord", "query"}
+	got := recipe.ExpectedDirs()
+	if len(got) != len(want) {
+		t.Fatalf("ExpectedDirs() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ExpectedDirs() = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestValidateSrcTree(t *testing.T) {
+	ver := Version{2, 225, 8, ""}
+	recipe := BuildRecipe{
+		IncludeDirs:    []string{".", "finlib"},
+		CxxIncludeDirs: []string{"corp", "concord"},
+	}
+	setup := func(t *testing.T) (string, string) {
+		root := t.TempDir()
+		srcDir := filepath.Join(root, manateeSrcDirName(ver))
+		for _, d := range recipe.ExpectedDirs() {
+			if err := os.MkdirAll(filepath.Join(srcDir, d), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		archivePath := filepath.Join(root, manateeArchiveName(ver))
+		if err := os.WriteFile(archivePath, []byte("archive data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return srcDir, archivePath
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(t *testing.T, srcDir, archivePath string)
+		wantErr bool
+	}{
+		{"complete", func(t *testing.T, srcDir, archivePath string) {}, false},
+		{"archive removed", func(t *testing.T, srcDir, archivePath string) {
+			os.Remove(archivePath)
+		}, false},
+		{"missing manifest", func(t *testing.T, srcDir, archivePath string) {
+			os.Remove(filepath.Join(srcDir, srcTreeManifestFileName))
+		}, true},
+		{"corrupted manifest", func(t *testing.T, srcDir, archivePath string) {
+			os.WriteFile(filepath.Join(srcDir, srcTreeManifestFileName), []byte("{\"vers"), 0644)
+		}, true},
+		{"missing directory", func(t *testing.T, srcDir, archivePath string) {
+			os.RemoveAll(filepath.Join(srcDir, "concord"))
+		}, true},
+		{"different archive", func(t *testing.T, srcDir, archivePath string) {
+			os.WriteFile(archivePath, []byte("other archive data"), 0644)
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srcDir, archivePath := setup(t)
+			if err := markSrcTreeComplete(srcDir, ver, recipe, archivePath); err != nil {
+				t.Fatal(err)
+			}
+			tt.modify(t, srcDir, archivePath)
+			err := validateSrcTree(srcDir, ver, recipe, archivePath)
+			if tt.wantErr && !errors.Is(err, ErrIncompleteSrcTree) {
+				t.Errorf("expected ErrIncompleteSrcTree, got %v", err)
+
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateSrcTreeOtherVersion(t *testing.T) {
+	srcDir := t.TempDir()
+	archivePath := filepath.Join(srcDir, "missing.tar.gz")
+	err := writeSrcTreeManifest(srcDir, srcTreeManifest{Version: "2.208.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = validateSrcTree(srcDir, Version{2, 225, 8, ""}, BuildRecipe{}, archivePath)
+	if !errors.Is(err, ErrIncompleteSrcTree) {
+		t.Errorf("expected ErrIncompleteSrcTree, got %v", err)
+	}
+}
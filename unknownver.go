@@ -0,0 +1,129 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	unknownVersionsLogFileName = "unknown-versions.jsonl"
+)
+
+// unknownVersionOutcome is a record of a build against a Manatee
+// version not listed in KnownVersions
+type unknownVersionOutcome struct {
+	Time            time.Time `json:"time"`
+	Version         string    `json:"version"`
+	Recipe          string    `json:"recipe"`
+	RecipeSource    string    `json:"recipeSource"`
+	Project         string    `json:"project"`
+	Success         bool      `json:"success"`
+	FailedOperation string    `json:"failedOperation,omitempty"`
+}
+
+// unknownVersionsLogPath returns a path of a file where outcomes
+// of builds against unknown versions are recorded (typically
+// $XDG_CACHE_HOME/manabuild/unknown-versions.jsonl, see Conf.CacheRoot)
+func unknownVersionsLogPath(cacheDir string) string {
+	return filepath.Join(cacheDir, unknownVersionsLogFileName)
+}
+
+func recordUnknownVersionOutcome(cacheDir string, outcome unknownVersionOutcome) (string, error) {
+	logPath := unknownVersionsLogPath(cacheDir)
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to record build outcome: %w", err)
+	}
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to record build outcome: %w", err)
+	}
+	defer f.Close()
+	data, err := json.Marshal(outcome)
+	if err != nil {
+		return "", fmt.Errorf("failed to record build outcome: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return "", fmt.Errorf("failed to record build outcome: %w", err)
+	}
+	return logPath, nil
+}
+
+// loadUnknownVersionOutcomes returns all the recorded outcomes
+// of builds against unknown versions. Malformed records are skipped.
+func loadUnknownVersionOutcomes(cacheDir string) ([]unknownVersionOutcome, error) {
+	ans := make([]unknownVersionOutcome, 0, 10)
+	f, err := os.Open(unknownVersionsLogPath(cacheDir))
+	if os.IsNotExist(err) {
+		return ans, nil
+
+	} else if err != nil {
+		return ans, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var item unknownVersionOutcome
+		if err := json.Unmarshal(scanner.Bytes(), &item); err == nil {
+			ans = append(ans, item)
+		}
+	}
+	return ans, scanner.Err()
+}
+
+// closestKnownVersion returns the highest known version lower than v
+// or (if there is no such version) the lowest known one.
+func closestKnownVersion(v Version, knownVersions []string) (Version, bool) {
+	var lower, lowest Version
+	for _, kv := range knownVersions {
+		pkv, err := ParseManateeVersion(kv)
+		if err != nil {
+			continue
+		}
+		if pkv.Le(v) && (lower.IsZero() || pkv.Gt(lower)) {
+			lower = pkv
+		}
+		if lowest.IsZero() || pkv.Lt(lowest) {
+			lowest = pkv
+		}
+	}
+	if !lower.IsZero() {
+		return lower, true
+	}
+	return lowest, !lowest.IsZero()
+}
+
+// FindClosest returns a recipe for a version not listed among the known
+// versions. A recipe explicitly matching the version is preferred,
+// otherwise the recipe of the closest known version is used.
+func (rr *RecipeRegistry) FindClosest(ver Version, knownVersions []string) (BuildRecipe, error) {
+	if recipe, err := rr.Find(ver); err == nil {
+		return recipe, nil
+	}
+	closest, ok := closestKnownVersion(ver, knownVersions)
+	if !ok {
+		return BuildRecipe{}, fmt.Errorf("no known version close to %s", ver.Full())
+	}
+	return rr.Find(closest)
+}
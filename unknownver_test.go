@@ -0,0 +1,102 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClosestKnownVersion(t *testing.T) {
+	known := []string{"2.167.8", "2.208", "2.225.8"}
+	tests := []struct {
+		input string
+		want  Version
+	}{
+		{"2.230.1", Version{2, 225, 8, ""}},
+		{"2.210.0", Version{2, 208, 0, ""}},
+		{"2.208.1-cnc", Version{2, 208, 0, ""}},
+		{"2.100.0", Version{2, 167, 8, ""}},
+	}
+	for _, tt := range tests {
+		v, err := ParseManateeVersion(tt.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := closestKnownVersion(v, known)
+		if !ok || !got.Eq(tt.want) {
+			t.Errorf("closestKnownVersion(%s) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+	if _, ok := closestKnownVersion(Version{2, 225, 8, ""}, []string{}); ok {
+		t.Error("expected no closest version for an empty list")
+	}
+}
+
+func TestFindClosestRecipe(t *testing.T) {
+	conf := NewConf()
+	conf.Recipes = []BuildRecipe{{Versions: "~2.167", CxxStd: "c++11"}}
+	recipes, err := LoadRecipes(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// drop the built-in catch-all recipes to test the fallback
+	recipes.recipes = recipes.recipes[:1]
+	recipe, err := recipes.FindClosest(Version{2, 168, 0, ""}, []string{"2.167.8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recipe.CxxStd != "c++11" {
+		t.Errorf("expected recipe of the closest known version, got %s", recipe.Versions)
+	}
+	if _, err := recipes.FindClosest(Version{2, 168, 0, ""}, []string{}); err == nil {
+		t.Error("expected error when no known version is available")
+	}
+}
+
+func TestRecordUnknownVersionOutcome(t *testing.T) {
+	cacheDir := t.TempDir()
+	for _, success := range []bool{false, true} {
+		outcome := unknownVersionOutcome{
+			Time:    time.Now(),
+			Version: "2.230.1",
+			Recipe:  ">=2.208",
+			Project: "/tmp/project",
+			Success: success,
+		}
+		if !success {
+			outcome.FailedOperation = "building target project"
+		}
+		if _, err := recordUnknownVersionOutcome(cacheDir, outcome); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outcomes, err := loadUnknownVersionOutcomes(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outcomes) != 2 || outcomes[0].Success || !outcomes[1].Success {
+		t.Errorf("unexpected outcomes %v", outcomes)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, unknownVersionsLogFileName)); err != nil {
+		t.Errorf("outcomes should be recorded in the cache directory: %v", err)
+	}
+}
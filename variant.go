@@ -0,0 +1,90 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	VariantUpstream = ""
+	VariantCNC      = "cnc"
+)
+
+// ManateeVariant describes a flavour of Manatee (e.g. upstream
+// manatee-open or the CNC-patched one). Different variants of
+// the same version number are not interchangeable.
+type ManateeVariant struct {
+
+	// Name is the version suffix identifying the variant
+	// (empty for upstream manatee-open)
+	Name string
+
+	// Description is a human-readable name of the variant
+	Description string
+
+	// DownloadURLs contains default mirror templates used to obtain
+	// the source archive of the variant. The templates are tried in
+	// the specified order (see expandMirrorTemplate for placeholders).
+	DownloadURLs []string
+
+	// ConfigureFlags are passed to the `./configure` script
+	// in addition to the default ones.
+	ConfigureFlags []string
+}
+
+var (
+	manateeVariants = map[string]ManateeVariant{
+		VariantUpstream: {
+			Name:        VariantUpstream,
+			Description: "upstream manatee-open",
+			DownloadURLs: []string{
+				"https://corpora.fi.muni.cz/noske/src/manatee-open/manatee-open-{version}.tar.gz",
+				"https://corpora.fi.muni.cz/noske/src/manatee-open/archive/manatee-open-{version}.tar.gz",
+				"http://corpora.fi.muni.cz/noske/current/src/manatee-open-{version}.tar.gz",
+			},
+		},
+		VariantCNC: {
+			Name:        VariantCNC,
+			Description: "CNC-patched manatee-open",
+			// There is no public download location of the patched
+			// sources so they must be provided via -manatee-src.
+			DownloadURLs: []string{},
+		},
+	}
+)
+
+// GetManateeVariant returns a variant of the provided name
+func GetManateeVariant(name string) (ManateeVariant, error) {
+	v, ok := manateeVariants[name]
+	if !ok {
+		known := make([]string, 0, len(manateeVariants))
+		for k := range manateeVariants {
+			if k != VariantUpstream {
+				known = append(known, k)
+			}
+		}
+		sort.Strings(known)
+		return ManateeVariant{}, fmt.Errorf(
+			"unknown Manatee variant %s (known variants: %s)", name, strings.Join(known, ", "))
+	}
+	return v, nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type versionCondition struct {
+	op  string
+	ver Version
+}
+
+func (vc versionCondition) check(v Version) bool {
+	// a condition without variant applies to all the variants,
+	// a condition with variant applies just to the variant
+	// (variants are not interchangeable)
+	if vc.ver.Variant != "" && v.Variant != vc.ver.Variant {
+		return false
+	}
+	cv := vc.ver
+	cv.Variant = ""
+	v.Variant = ""
+	c := v.Compare(cv)
+	switch vc.op {
+	case "=":
+		return c == 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+	return false
+}
+
+// VersionConstraint represents a set of conditions
+// a Manatee version must satisfy. Supported expressions:
+//
+//   - exact version: 2.225.8, 2.208 (= 2.208.0)
+//   - comparison: >=2.208, >2.208, <=2.225.8, <2.225
+//   - tilde range (patch releases only): ~2.225 (>=2.225.0, <2.226.0),
+//     ~2.225.3 (>=2.225.3, <2.226.0)
+//   - wildcard: 2.223.x (>=2.223.0, <2.224.0), 2.x (>=2.0.0, <3.0.0)
+//
+// Multiple expressions separated by commas or spaces must all
+// be satisfied (e.g. ">=2.208, <2.225"). An expression without
+// a variant matches all the variants while an expression with
+// a variant (e.g. ~2.225-cnc) matches just the variant.
+type VersionConstraint struct {
+	raw        string
+	conditions []versionCondition
+}
+
+func (vc VersionConstraint) String() string {
+	return vc.raw
+}
+
+// Check tests whether the version satisfies the constraint
+func (vc VersionConstraint) Check(v Version) bool {
+	for _, cond := range vc.conditions {
+		if !cond.check(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// ExactVersion returns a version in case the constraint
+// specifies an exact version.
+func (vc VersionConstraint) ExactVersion() (Version, bool) {
+	if len(vc.conditions) == 1 && vc.conditions[0].op == "=" {
+		return vc.conditions[0].ver, true
+	}
+	return Version{}, false
+}
+
+// Best returns the highest of the provided versions satisfying
+// the constraint. If there is no such version, false is returned.
+func (vc VersionConstraint) Best(versions []Version) (Version, bool) {
+	matching := make([]Version, 0, len(versions))
+	for _, v := range versions {
+		if vc.Check(v) {
+			matching = append(matching, v)
+		}
+	}
+	if len(matching) == 0 {
+		return Version{}, false
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return matching[i].Lt(matching[j])
+	})
+	return matching[len(matching)-1], true
+}
+
+func parseVersionExpr(expr string) ([]versionCondition, error) {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(expr, op) {
+			v, err := ParseManateeVersion(strings.TrimSpace(expr[len(op):]))
+			if err != nil {
+				return []versionCondition{}, err
+			}
+			return []versionCondition{{op: op, ver: v}}, nil
+		}
+	}
+	if strings.HasPrefix(expr, "~") {
+		v, err := ParseManateeVersion(expr[1:])
+		if err != nil {
+			return []versionCondition{}, err
+		}
+		upper := Version{Major: v.Major, Minor: v.Minor + 1, Variant: v.Variant}
+		return []versionCondition{{op: ">=", ver: v}, {op: "<", ver: upper}}, nil
+	}
+	if strings.HasSuffix(expr, ".x") || strings.HasSuffix(expr, ".*") {
+		items := strings.Split(expr[:len(expr)-2], ".")
+		var lower, upper Version
+		switch len(items) {
+		case 1:
+			v, err := ParseManateeVersion(items[0] + ".0")
+			if err != nil {
+				return []versionCondition{}, err
+			}
+			lower = v
+			upper = Version{Major: v.Major + 1}
+		case 2:
+			v, err := ParseManateeVersion(items[0] + "." + items[1])
+			if err != nil {
+				return []versionCondition{}, err
+			}
+			lower = v
+			upper = Version{Major: v.Major, Minor: v.Minor + 1}
+		default:
+			return []versionCondition{}, fmt.Errorf("invalid wildcard version %s", expr)
+		}
+		return []versionCondition{{op: ">=", ver: lower}, {op: "<", ver: upper}}, nil
+	}
+	v, err := ParseManateeVersion(expr)
+	if err != nil {
+		return []versionCondition{}, err
+	}
+	return []versionCondition{{op: "=", ver: v}}, nil
+}
+
+// ParseVersionConstraint parses a version constraint
+// expression (see VersionConstraint for the syntax).
+func ParseVersionConstraint(expr string) (VersionConstraint, error) {
+	ans := VersionConstraint{raw: strings.TrimSpace(expr)}
+	// allow spaces between operators and versions (e.g. ">= 2.208")
+	normalized := expr
+	for _, op := range []string{">=", "<=", ">", "<", "=", "~"} {
+		normalized = strings.ReplaceAll(normalized, op+" ", op)
+	}
+	items := strings.FieldsFunc(normalized, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	if len(items) == 0 {
+		return ans, fmt.Errorf("empty version constraint")
+	}
+	for _, item := range items {
+		conds, err := parseVersionExpr(item)
+		if err != nil {
+			return ans, fmt.Errorf("invalid version constraint %s: %w", expr, err)
+		}
+		ans.conditions = append(ans.conditions, conds...)
+	}
+	return ans, nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"testing"
+)
+
+func mustParseVersions(t *testing.T, versions ...string) []Version {
+	t.Helper()
+	ans := make([]Version, len(versions))
+	for i, v := range versions {
+		pv, err := ParseManateeVersion(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ans[i] = pv
+	}
+	return ans
+}
+
+func TestParseVersionConstraintErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"   ",
+		",",
+		">=",
+		"~",
+		"2",
+		"2.x.x",
+		"2.1.1.x",
+		">=2.foo",
+		"~2.225.8-foo",
+		">=2.208, <abc",
+	}
+	for _, expr := range tests {
+		if _, err := ParseVersionConstraint(expr); err == nil {
+			t.Errorf("expected error for constraint %q", expr)
+		}
+	}
+}
+
+func TestVersionConstraintCheck(t *testing.T) {
+	tests := []struct {
+		expr     string
+		matching []string
+		other    []string
+	}{
+		{"2.225.8", []string{"2.225.8"}, []string{"2.225.7", "2.225.9", "2.226.8"}},
+		{"2.208", []string{"2.208.0"}, []string{"2.208.1"}},
+		{"=2.223.6", []string{"2.223.6"}, []string{"2.223.5"}},
+		{">=2.208", []string{"2.208.0", "2.223.6", "3.0.0"}, []string{"2.167.10"}},
+		{">= 2.208", []string{"2.208.0", "2.225.8"}, []string{"2.167.8"}},
+		{">2.208", []string{"2.208.1", "2.214.1"}, []string{"2.208.0", "2.167.8"}},
+		{"<2.225", []string{"2.223.6", "2.167.8"}, []string{"2.225.0", "2.225.8"}},
+		{"<=2.225.8", []string{"2.225.8", "2.208.0"}, []string{"2.225.9"}},
+		{"~2.225", []string{"2.225.0", "2.225.8"}, []string{"2.224.9", "2.226.0"}},
+		{"~2.225.3", []string{"2.225.3", "2.225.8"}, []string{"2.225.2", "2.226.0"}},
+		{"~ 2.225", []string{"2.225.8"}, []string{"2.226.0"}},
+		{"2.223.x", []string{"2.223.0", "2.223.6"}, []string{"2.222.9", "2.224.0"}},
+		{"2.223.*", []string{"2.223.6"}, []string{"2.224.0"}},
+		{"2.x", []string{"2.0.0", "2.225.8"}, []string{"1.9.9", "3.0.0"}},
+		{">=2.208, <2.225", []string{"2.208.0", "2.223.6"}, []string{"2.167.10", "2.225.8"}},
+		{">=2.208 <2.225", []string{"2.214.1"}, []string{"2.225.0"}},
+		{">= 2.208 , < 2.225", []string{"2.214.1"}, []string{"2.225.0", "2.167.8"}},
+		// conditions without a variant apply to all the variants
+		{">=2.208", []string{"2.225.8-cnc"}, []string{}},
+		{"~2.225", []string{"2.225.8-cnc"}, []string{"2.226.0-cnc"}},
+		// conditions with a variant apply just to the variant
+		{"2.225.8-cnc", []string{"2.225.8-cnc"}, []string{"2.225.8"}},
+		{"~2.225-cnc", []string{"2.225.0-cnc", "2.225.8-cnc"}, []string{"2.225.8", "2.226.0-cnc"}},
+		{">=2.208-cnc", []string{"2.208.0-cnc", "2.225.8-cnc"}, []string{"2.208.0", "2.223.6", "2.226.0"}},
+		{"<2.225-cnc", []string{"2.223.6-cnc"}, []string{"2.223.6", "2.225.0-cnc"}},
+		{">=2.208-cnc, <2.225", []string{"2.223.6-cnc"}, []string{"2.223.6", "2.225.8-cnc"}},
+	}
+	for _, tt := range tests {
+		constr, err := ParseVersionConstraint(tt.expr)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.expr, err)
+			continue
+		}
+		for _, v := range mustParseVersions(t, tt.matching...) {
+			if !constr.Check(v) {
+				t.Errorf("%q should match %s", tt.expr, v.Full())
+			}
+		}
+		for _, v := range mustParseVersions(t, tt.other...) {
+			if constr.Check(v) {
+				t.Errorf("%q should not match %s", tt.expr, v.Full())
+			}
+		}
+	}
+}
+
+func TestVersionConstraintExactVersion(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    Version
+		isExact bool
+	}{
+		{"2.225.8", Version{2, 225, 8, ""}, true},
+		{"=2.225.8-cnc", Version{2, 225, 8, "cnc"}, true},
+		{"2.208", Version{2, 208, 0, ""}, true},
+		{">=2.208", Version{}, false},
+		{"~2.225", Version{}, false},
+		{"2.223.x", Version{}, false},
+	}
+	for _, tt := range tests {
+		constr, err := ParseVersionConstraint(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, isExact := constr.ExactVersion()
+		if isExact != tt.isExact || !got.Eq(tt.want) {
+			t.Errorf("%q: ExactVersion() = %s, %v, want %s, %v", tt.expr, got.Full(), isExact, tt.want.Full(), tt.isExact)
+		}
+	}
+}
+
+func TestVersionConstraintBest(t *testing.T) {
+	versions := mustParseVersions(t, "2.167.8", "2.225.8", "2.208", "2.223.6", "2.225.8-cnc", "2.214.1")
+	tests := []struct {
+		expr  string
+		want  string
+		found bool
+	}{
+		{">=2.208", "2.225.8-cnc", true},
+		{"<2.225", "2.223.6", true},
+		{"~2.208", "2.208.0", true},
+		{"2.167.x", "2.167.8", true},
+		{">=3.0", "", false},
+		{"~2.225-cnc", "2.225.8-cnc", true},
+		{">=2.208-cnc", "2.225.8-cnc", true},
+		{"<2.225-cnc", "", false},
+	}
+	for _, tt := range tests {
+		constr, err := ParseVersionConstraint(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, found := constr.Best(versions)
+		if found != tt.found || (found && got.Full() != tt.want) {
+			t.Errorf("%q: Best() = %s, %v, want %s, %v", tt.expr, got.Full(), found, tt.want, tt.found)
+		}
+	}
+	if _, found := (VersionConstraint{}).Best([]Version{}); found {
+		t.Error("Best() of no versions should find nothing")
+	}
+}
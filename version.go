@@ -19,9 +19,10 @@
 package main
 
 import (
+	"debug/elf"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
@@ -29,12 +30,26 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/czcorpus/cnc-gokit/collections"
 	"github.com/czcorpus/cnc-gokit/fs"
 )
 
 var (
-	VerSrchPtrn = regexp.MustCompile(`open-([^\s]+)`)
+	VerSrchPtrn = regexp.MustCompile(`open-(\d+\.\d+(?:\.\d+)?(?:-[a-z0-9]+)?)`)
+
+	ErrNoVersionMarker = errors.New("no Manatee version marker found")
+
+	ErrVersionMismatch = errors.New("Manatee version mismatch")
+
+	// srcVersionMarkers are searched (in the specified order)
+	// to determine a version of a Manatee source tree
+	srcVersionMarkers = []struct {
+		file string
+		ptrn *regexp.Regexp
+	}{
+		{"VERSION", regexp.MustCompile(`^\s*(\S+)\s*$`)},
+		{"config.hh", regexp.MustCompile(`#define\s+(?:PACKAGE_)?VERSION\s+"([^"]+)"`)},
+		{"configure", regexp.MustCompile(`PACKAGE_VERSION='([^']+)'`)},
+	}
 )
 
 type Version struct {
@@ -52,37 +67,94 @@ func (v Version) Semver() string {
 	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
 }
 
+// Full returns a semver including a variant suffix (if any),
+// e.g. 2.225.8-cnc. This is also the format of directory names
+// in /opt/manatee.
+func (v Version) Full() string {
+	if v.Variant != "" {
+		return v.Semver() + "-" + v.Variant
+	}
+	return v.Semver()
+}
+
+// ManateeVariant returns a description of the version's variant
+func (v Version) ManateeVariant() ManateeVariant {
+	return manateeVariants[v.Variant]
+}
+
 func (v Version) String() string {
 	var vs string
 	if v.Variant != "" {
-		vs = "-" + vs
+		vs = "-" + v.Variant
 	}
 	return fmt.Sprintf(
 		"manatee-open-%d.%d.%d%s", v.Major, v.Minor, v.Patch, vs)
 }
 
-func (v Version) Ge(other Version) bool {
-	if v.Major != other.Major {
-		return v.Major > other.Major
+// Compare compares the version with other one and returns
+// -1 if v < other, 0 if v == other and 1 if v > other.
+// Versions with the same numbers are ordered by their variants
+// with the empty variant (i.e. upstream Manatee) being the lowest.
+func (v Version) Compare(other Version) int {
+	switch {
+	case v.Major != other.Major:
+		return cmpInts(v.Major, other.Major)
+	case v.Minor != other.Minor:
+		return cmpInts(v.Minor, other.Minor)
+	case v.Patch != other.Patch:
+		return cmpInts(v.Patch, other.Patch)
 	}
-	if v.Minor != other.Minor {
-		return v.Minor > other.Minor
-	}
-	return v.Patch > other.Patch
+	return strings.Compare(v.Variant, other.Variant)
+}
+
+func (v Version) Lt(other Version) bool {
+	return v.Compare(other) < 0
+}
+
+func (v Version) Le(other Version) bool {
+	return v.Compare(other) <= 0
+}
+
+func (v Version) Gt(other Version) bool {
+	return v.Compare(other) > 0
+}
+
+func (v Version) Ge(other Version) bool {
+	return v.Compare(other) >= 0
 }
 
 func (v Version) Eq(other Version) bool {
-	return v.Major == other.Major && v.Minor == other.Minor && v.Patch == other.Patch
+	return v.Compare(other) == 0
 }
 
+func cmpInts(a, b int) int {
+	if a < b {
+		return -1
+
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// ParseManateeVersion parses a version string (e.g. 2.225.8,
+// 2.208, 2.225.8-cnc). A suffix following the numeric part
+// specifies a variant which must be a known one.
 func ParseManateeVersion(v string) (Version, error) {
-	v = strings.TrimSuffix(v, "-cnc")
-	items := strings.Split(v, ".")
+	var ans Version
+	numPart, variant, hasVariant := strings.Cut(v, "-")
+	if hasVariant && variant == "" {
+		return ans, fmt.Errorf("invalid version specifier %s: empty variant", v)
+	}
+	if _, err := GetManateeVariant(variant); err != nil {
+		return ans, fmt.Errorf("invalid version specifier %s: %w", v, err)
+	}
+	ans.Variant = variant
+	items := strings.Split(numPart, ".")
 	if len(items) < 2 || len(items) > 3 {
 		return Version{}, fmt.Errorf("invalid version specifier: %s", v)
 	}
 	var err error
-	var ans Version
 	ans.Major, err = strconv.Atoi(items[0])
 	if err != nil {
 		return ans, err
@@ -98,26 +170,133 @@ func ParseManateeVersion(v string) (Version, error) {
 	return ans, nil
 }
 
+// AutodetectManateeVersion determines a version of Manatee installed
+// in the system. In case specPath is defined, just the library found
+// there is examined. Otherwise, /usr/local/lib/libmanatee.so is
+// preferred, then the libraries found by FindManateeInstallations
+// (see pickInstalledManateeVersion) and finally the directories
+// in /opt/manatee. A zero version is returned in case nothing
+// suitable is found.
 func AutodetectManateeVersion(specPath string, knownVersions []string) (Version, error) {
-
-	libPath := DefaultManateeLibPath
 	if specPath != "" {
-		libPath = path.Join(specPath, "libmanatee.so")
+		libPath := path.Join(specPath, "libmanatee.so")
+		if fs.PathExists(libPath) {
+			return libManateeVersion(libPath)
+		}
+		return findLatestManateeInOpt(knownVersions)
 	}
-	if fs.PathExists(libPath) {
-		cmd := exec.Command("strings", libPath)
-		out, err := cmd.CombinedOutput()
-		if err == nil {
-			srch := VerSrchPtrn.FindStringSubmatch(string(out))
-			return ParseManateeVersion(srch[1])
+	if fs.PathExists(DefaultManateeLibPath) {
+		return libManateeVersion(DefaultManateeLibPath)
+	}
+	if v, ok := pickInstalledManateeVersion(FindManateeInstallations(), knownVersions); ok {
+		return v, nil
+	}
+	return findLatestManateeInOpt(knownVersions)
+}
 
-		} else {
-			return Version{}, fmt.Errorf("failed to run `strings %s`", libPath)
+// pickInstalledManateeVersion selects the highest known version
+// of linkable (i.e. unversioned libmanatee.so) installations. The ones
+// found by the dynamic loader without LD_LIBRARY_PATH are preferred.
+func pickInstalledManateeVersion(insts []ManateeInstallation, knownVersions []string) (Version, bool) {
+	var ans Version
+	var ansOnDefaultPath, found bool
+	for _, inst := range insts {
+		if inst.VersionErr != nil || filepath.Base(inst.LibPath) != "libmanatee.so" ||
+			!isKnownVersion(inst.Version, knownVersions) {
+			continue
 		}
+		if !found || inst.OnDefaultPath && !ansOnDefaultPath ||
+			inst.OnDefaultPath == ansOnDefaultPath && inst.Version.Gt(ans) {
+			ans = inst.Version
+			ansOnDefaultPath = inst.OnDefaultPath
+			found = true
+		}
+	}
+	return ans, found
+}
 
-	} else {
-		return findLatestManateeInOpt(knownVersions)
+// libManateeVersion detects a version of a libmanatee.so file
+// by searching its read-only data sections for a version marker
+// (e.g. `manatee-open-2.225.8`).
+func libManateeVersion(libPath string) (Version, error) {
+	f, err := elf.Open(libPath)
+	if err != nil {
+		return Version{}, fmt.Errorf("failed to read %s: %w", libPath, err)
 	}
+	defer f.Close()
+	for _, sec := range f.Sections {
+		if sec.Type != elf.SHT_PROGBITS || sec.Flags&elf.SHF_ALLOC == 0 ||
+			sec.Flags&(elf.SHF_WRITE|elf.SHF_EXECINSTR) != 0 {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil {
+			return Version{}, fmt.Errorf("failed to read section %s of %s: %w", sec.Name, libPath, err)
+		}
+		if srch := VerSrchPtrn.FindSubmatch(data); srch != nil {
+			return ParseManateeVersion(string(srch[1]))
+		}
+	}
+	return Version{}, fmt.Errorf("failed to determine version of %s: %w", libPath, ErrNoVersionMarker)
+}
+
+// srcManateeVersion detects a version of a Manatee source tree
+// based on VERSION file, config.hh (available once the sources are
+// configured) or the configure script.
+func srcManateeVersion(srcDir string) (Version, error) {
+	for _, marker := range srcVersionMarkers {
+		data, err := os.ReadFile(filepath.Join(srcDir, marker.file))
+		if os.IsNotExist(err) {
+			continue
+
+		} else if err != nil {
+			return Version{}, fmt.Errorf("failed to read %s: %w", marker.file, err)
+		}
+		if srch := marker.ptrn.FindSubmatch(data); srch != nil {
+			return ParseManateeVersion(string(srch[1]))
+		}
+	}
+	return Version{}, fmt.Errorf("failed to determine version of sources in %s: %w", srcDir, ErrNoVersionMarker)
+}
+
+// findInstalledManateeVersions returns versions of all the Manatee
+// installations found in the system (see FindManateeInstallations).
+// In case specPath is defined, only the library found there is examined.
+func findInstalledManateeVersions(specPath string) []Version {
+	ans := make([]Version, 0, 10)
+	if specPath != "" {
+		libPath := path.Join(specPath, "libmanatee.so")
+		if v, err := libManateeVersion(libPath); err == nil {
+			ans = append(ans, v)
+		}
+		return ans
+	}
+	for _, inst := range FindManateeInstallations() {
+		if inst.VersionErr == nil {
+			ans = append(ans, inst.Version)
+		}
+	}
+	entries, err := os.ReadDir("/opt/manatee")
+	if err != nil {
+		return ans
+	}
+	for _, ent := range entries {
+		if v, err := ParseManateeVersion(ent.Name()); err == nil {
+			ans = append(ans, v)
+		}
+	}
+	return ans
+}
+
+// isKnownVersion tests whether the version (regardless of its variant)
+// is listed in knownVersions
+func isKnownVersion(v Version, knownVersions []string) bool {
+	for _, kv := range knownVersions {
+		if pkv, err := ParseManateeVersion(kv); err == nil && pkv.Semver() == v.Semver() {
+			return true
+		}
+	}
+	return false
 }
 
 func findLatestManateeInOpt(knownVersions []string) (Version, error) {
@@ -128,30 +307,81 @@ func findLatestManateeInOpt(knownVersions []string) (Version, error) {
 	foundVersions := make([]Version, 0, 10)
 	for _, ent := range entries {
 		if v, err := ParseManateeVersion(ent.Name()); err == nil {
-			if collections.SliceContains(knownVersions, v.Semver()) {
+			if isKnownVersion(v, knownVersions) {
 				foundVersions = append(foundVersions, v)
 			}
 		}
 	}
 	if len(foundVersions) > 0 {
 		sort.SliceStable(foundVersions, func(i, j int) bool {
-			return foundVersions[j].Ge(foundVersions[i])
+			return foundVersions[i].Lt(foundVersions[j])
 		})
 		return foundVersions[len(foundVersions)-1], nil
 	}
 	return Version{}, nil
 }
 
-func findManatee(version Version) string {
-	if fs.PathExists("/usr/lib/libmanatee.so") {
-		return "/usr/lib"
+// rejectedManateeLib describes a library found by findManatee
+// which cannot be used for the required version
+type rejectedManateeLib struct {
+	Dir    string
+	Reason string
+}
+
+// manateeLibCandidateDirs returns directories searched for libmanatee.so
+// in the order of preference. Only directories containing the unversioned
+// libmanatee.so are considered as linking requires it.
+func manateeLibCandidateDirs(version Version) []string {
+	ans := []string{
+		"/usr/lib",
+		"/usr/local/lib",
+		fmt.Sprintf("/opt/manatee/%s/lib", version.Full()),
 	}
-	if fs.PathExists("/usr/local/lib/libmanatee.so") {
-		return "/usr/local/lib"
+	for _, inst := range FindManateeInstallations() {
+		if filepath.Base(inst.LibPath) == "libmanatee.so" {
+			ans = append(ans, inst.Dir)
+		}
 	}
-	optInstPath := fmt.Sprintf("/opt/manatee/%s/lib", version.Semver())
-	if fs.PathExists(filepath.Join(optInstPath, "libmanatee.so")) {
-		return optInstPath
+	return ans
+}
+
+// findManatee searches for a directory containing libmanatee.so
+// of the required version. All the found libraries of a different
+// (or undetectable) version are returned along with a reason
+// of their rejection.
+func findManatee(version Version) (string, []rejectedManateeLib) {
+	if version.IsZero() {
+		return "", []rejectedManateeLib{}
+	}
+	return findManateeIn(manateeLibCandidateDirs(version), version)
+}
+
+func findManateeIn(dirs []string, version Version) (string, []rejectedManateeLib) {
+	rejected := make([]rejectedManateeLib, 0, 5)
+	visited := make(map[string]bool)
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if visited[dir] {
+			continue
+		}
+		visited[dir] = true
+		libPath := filepath.Join(dir, "libmanatee.so")
+		if !fs.PathExists(libPath) {
+			continue
+		}
+		libVersion, err := libManateeVersion(libPath)
+		if err != nil {
+			rejected = append(rejected, rejectedManateeLib{dir, err.Error()})
+
+		} else if !libVersion.Eq(version) {
+			rejected = append(
+				rejected,
+				rejectedManateeLib{dir, fmt.Sprintf("version %s, required %s", libVersion.Full(), version.Full())},
+			)
+
+		} else {
+			return dir, rejected
+		}
 	}
-	return ""
+	return "", rejected
 }
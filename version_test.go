@@ -0,0 +1,382 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b Version
+		want int
+	}{
+		{Version{2, 208, 0, ""}, Version{2, 208, 0, ""}, 0},
+		{Version{2, 208, 0, ""}, Version{2, 208, 1, ""}, -1},
+		{Version{2, 208, 1, ""}, Version{2, 208, 0, ""}, 1},
+		{Version{2, 167, 10, ""}, Version{2, 208, 0, ""}, -1},
+		{Version{2, 225, 8, ""}, Version{2, 214, 1, ""}, 1},
+		{Version{3, 0, 0, ""}, Version{2, 225, 8, ""}, 1},
+		{Version{1, 999, 999, ""}, Version{2, 0, 0, ""}, -1},
+		{Version{2, 225, 8, ""}, Version{2, 225, 8, "cnc"}, -1},
+		{Version{2, 225, 8, "cnc"}, Version{2, 225, 8, ""}, 1},
+		{Version{2, 225, 8, "cnc"}, Version{2, 225, 8, "cnc"}, 0},
+		{Version{2, 225, 8, "cnc"}, Version{2, 225, 9, ""}, -1},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Compare(tt.b); got != tt.want {
+			t.Errorf("%s.Compare(%s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestVersionOrderingPredicates(t *testing.T) {
+	v2_208_0 := Version{2, 208, 0, ""}
+	tests := []struct {
+		name string
+		a, b Version
+		lt   bool
+		le   bool
+		eq   bool
+		ge   bool
+		gt   bool
+	}{
+		{"equal", v2_208_0, v2_208_0, false, true, true, true, false},
+		{"lower", Version{2, 167, 10, ""}, v2_208_0, true, true, false, false, false},
+		{"higher", Version{2, 208, 1, ""}, v2_208_0, false, false, false, true, true},
+		{"variant higher", Version{2, 208, 0, "cnc"}, v2_208_0, false, false, false, true, true},
+		{"variant lower", v2_208_0, Version{2, 208, 0, "cnc"}, true, true, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.Lt(tt.b); got != tt.lt {
+				t.Errorf("Lt() = %v, want %v", got, tt.lt)
+			}
+			if got := tt.a.Le(tt.b); got != tt.le {
+				t.Errorf("Le() = %v, want %v", got, tt.le)
+			}
+			if got := tt.a.Eq(tt.b); got != tt.eq {
+				t.Errorf("Eq() = %v, want %v", got, tt.eq)
+			}
+			if got := tt.a.Ge(tt.b); got != tt.ge {
+				t.Errorf("Ge() = %v, want %v", got, tt.ge)
+			}
+			if got := tt.a.Gt(tt.b); got != tt.gt {
+				t.Errorf("Gt() = %v, want %v", got, tt.gt)
+			}
+		})
+	}
+}
+
+func TestVersionSortIsTotal(t *testing.T) {
+	versions := []Version{
+		{2, 225, 8, "cnc"},
+		{2, 167, 8, ""},
+		{2, 225, 8, ""},
+		{2, 208, 0, ""},
+		{2, 167, 10, ""},
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Lt(versions[j])
+	})
+	want := []Version{
+		{2, 167, 8, ""},
+		{2, 167, 10, ""},
+		{2, 208, 0, ""},
+		{2, 225, 8, ""},
+		{2, 225, 8, "cnc"},
+	}
+	for i := range want {
+		if !versions[i].Eq(want[i]) {
+			t.Errorf("position %d: got %s, want %s", i, versions[i], want[i])
+		}
+	}
+}
+
+func TestVersionString(t *testing.T) {
+	tests := []struct {
+		v    Version
+		want string
+	}{
+		{Version{2, 225, 8, ""}, "manatee-open-2.225.8"},
+		{Version{2, 225, 8, "cnc"}, "manatee-open-2.225.8-cnc"},
+	}
+	for _, tt := range tests {
+		if got := tt.v.String(); got != tt.want {
+			t.Errorf("String() = %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestParseManateeVersion(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Version
+		wantErr bool
+	}{
+		{"2.225.8", Version{2, 225, 8, ""}, false},
+		{"2.208", Version{2, 208, 0, ""}, false},
+		{"2.225.8-cnc", Version{2, 225, 8, "cnc"}, false},
+		{"2.225.8-foo", Version{}, true},
+		{"2.225.8-", Version{}, true},
+		{"2.208-", Version{}, true},
+		{"2", Version{}, true},
+		{"2.x.1", Version{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseManateeVersion(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseManateeVersion(%s) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !got.Eq(tt.want) {
+			t.Errorf("ParseManateeVersion(%s) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+// The fixture libraries in testdata were produced by compiling
+// a single string constant into a minimal shared object, e.g.:
+//
+//	const char *manatee_version(void) { return "manatee-open-2.225.8"; }
+//
+// gcc -shared -fPIC -nostdlib -Os -s -o libmanatee-2.225.8.so m.c
+func TestLibManateeVersion(t *testing.T) {
+	tests := []struct {
+		file    string
+		want    Version
+		wantErr error
+	}{
+		{"testdata/libmanatee-2.225.8.so", Version{2, 225, 8, ""}, nil},
+		{"testdata/libmanatee-2.225.8-cnc.so", Version{2, 225, 8, "cnc"}, nil},
+		{"testdata/libmanatee-nomarker.so", Version{}, ErrNoVersionMarker},
+		// the marker is searched in read-only data only
+		{"testdata/libmanatee-writable.so", Version{}, ErrNoVersionMarker},
+	}
+	for _, tt := range tests {
+		got, err := libManateeVersion(tt.file)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: expected error %v, got %v", tt.file, tt.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.file, err)
+
+		} else if !got.Eq(tt.want) {
+			t.Errorf("%s: got %s, want %s", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestLibManateeVersionNotELF(t *testing.T) {
+	if _, err := libManateeVersion("version_test.go"); err == nil {
+		t.Error("expected error for a non-ELF file")
+	}
+}
+
+func TestFindManateeIn(t *testing.T) {
+	root := t.TempDir()
+	libs := map[string]string{
+		"usr-lib":   "testdata/libmanatee-2.225.8-cnc.so",
+		"broken":    "testdata/libmanatee-nomarker.so",
+		"opt-match": "testdata/libmanatee-2.225.8.so",
+	}
+	for dir, fixture := range libs {
+		data, err := os.ReadFile(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, dir, "libmanatee.so"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dirs := []string{
+		filepath.Join(root, "usr-lib"),
+		filepath.Join(root, "missing"),
+		filepath.Join(root, "broken"),
+		filepath.Join(root, "opt-match"),
+	}
+	found, rejected := findManateeIn(dirs, Version{2, 225, 8, ""})
+	if found != filepath.Join(root, "opt-match") {
+		t.Errorf("expected the exact match in opt-match, got %s", found)
+	}
+	if len(rejected) != 2 {
+		t.Fatalf("expected 2 rejected libraries, got %v", rejected)
+	}
+	if rejected[0].Dir != dirs[0] || rejected[1].Dir != dirs[2] {
+		t.Errorf("unexpected rejected libraries %v", rejected)
+	}
+
+	found, rejected = findManateeIn(dirs, Version{2, 208, 0, ""})
+	if found != "" || len(rejected) != 3 {
+		t.Errorf("expected no match and 3 rejected libraries, got %s, %v", found, rejected)
+	}
+}
+
+func TestPickInstalledManateeVersion(t *testing.T) {
+	known := []string{"2.208", "2.223.6", "2.225.8"}
+	tests := []struct {
+		name  string
+		insts []ManateeInstallation
+		want  Version
+		found bool
+	}{
+		{"nothing installed", []ManateeInstallation{}, Version{}, false},
+		{
+			"ld.so.conf directory only",
+			[]ManateeInstallation{
+				{LibPath: "/opt/lib/manatee/libmanatee.so", Version: Version{2, 223, 6, ""}, OnDefaultPath: true},
+			},
+			Version{2, 223, 6, ""}, true,
+		},
+		{
+			"highest version",
+			[]ManateeInstallation{
+				{LibPath: "/usr/lib/libmanatee.so", Version: Version{2, 208, 0, ""}, OnDefaultPath: true},
+				{LibPath: "/usr/lib64/libmanatee.so", Version: Version{2, 225, 8, ""}, OnDefaultPath: true},
+			},
+			Version{2, 225, 8, ""}, true,
+		},
+		{
+			"default loader path preferred",
+			[]ManateeInstallation{
+				{LibPath: "/home/me/lib/libmanatee.so", Version: Version{2, 225, 8, ""}},
+				{LibPath: "/usr/lib/libmanatee.so", Version: Version{2, 208, 0, ""}, OnDefaultPath: true},
+			},
+			Version{2, 208, 0, ""}, true,
+		},
+		{
+			"unsuitable libraries skipped",
+			[]ManateeInstallation{
+				{LibPath: "/usr/lib/libmanatee.so.3", Version: Version{2, 225, 8, ""}, OnDefaultPath: true},
+				{LibPath: "/usr/lib64/libmanatee.so", VersionErr: ErrNoVersionMarker, OnDefaultPath: true},
+				{LibPath: "/usr/lib32/libmanatee.so", Version: Version{2, 300, 0, ""}, OnDefaultPath: true},
+				{LibPath: "/home/me/lib/libmanatee.so", Version: Version{2, 208, 0, ""}},
+			},
+			Version{2, 208, 0, ""}, true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := pickInstalledManateeVersion(tt.insts, known)
+			if found != tt.found || !got.Eq(tt.want) {
+				t.Errorf("got %s, %v, want %s, %v", got.Full(), found, tt.want.Full(), tt.found)
+			}
+		})
+	}
+}
+
+func TestSrcManateeVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    Version
+		wantErr error
+	}{
+		{
+			"VERSION file",
+			map[string]string{"VERSION": "2.225.8\n"},
+			Version{2, 225, 8, ""}, nil,
+		},
+		{
+			"VERSION file with variant",
+			map[string]string{"VERSION": " 2.225.8-cnc "},
+			Version{2, 225, 8, "cnc"}, nil,
+		},
+		{
+			"configured sources",
+			map[string]string{"config.hh": "#define HAVE_PCRE 1\n#define VERSION \"2.223.6\"\n"},
+			Version{2, 223, 6, ""}, nil,
+		},
+		{
+			"configured sources with PACKAGE_VERSION",
+			map[string]string{"config.hh": "#define PACKAGE_VERSION \"2.214.1\"\n"},
+			Version{2, 214, 1, ""}, nil,
+		},
+		{
+			"configure script",
+			map[string]string{"configure": "#!/bin/sh\nPACKAGE_NAME='manatee-open'\nPACKAGE_VERSION='2.208'\n"},
+			Version{2, 208, 0, ""}, nil,
+		},
+		{
+			"VERSION preferred",
+			map[string]string{
+				"VERSION":   "2.225.8",
+				"config.hh": "#define VERSION \"2.223.6\"\n",
+				"configure": "PACKAGE_VERSION='2.214.1'\n",
+			},
+			Version{2, 225, 8, ""}, nil,
+		},
+		{
+			"config.hh without version falls back to configure",
+			map[string]string{
+				"config.hh": "#define HAVE_PCRE 1\n",
+				"configure": "PACKAGE_VERSION='2.214.1'\n",
+			},
+			Version{2, 214, 1, ""}, nil,
+		},
+		{
+			"no marker",
+			map[string]string{"Makefile": "all:\n"},
+			Version{}, ErrNoVersionMarker,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srcDir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := srcManateeVersion(srcDir)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("expected error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error %v", err)
+
+			} else if !got.Eq(tt.want) {
+				t.Errorf("got %s, want %s", got.Full(), tt.want.Full())
+			}
+		})
+	}
+}
+
+func TestSrcManateeVersionInvalid(t *testing.T) {
+	srcDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcDir, "VERSION"), []byte("2.225.8-foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srcManateeVersion(srcDir); err == nil {
+		t.Error("expected error for an invalid version")
+	}
+}
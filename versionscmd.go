@@ -0,0 +1,240 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	inventoryKindSupported = "supported"
+	inventoryKindLibrary   = "library"
+	inventoryKindTried     = "tried"
+)
+
+// inventoryItem is a single Manatee version known to manabuild
+// in some form (supported version, installed library, source tree
+// or downloaded archive)
+type inventoryItem struct {
+	Kind         string `json:"kind"`
+	Version      string `json:"version"`
+	Path         string `json:"path,omitempty"`
+	Note         string `json:"note,omitempty"`
+	AutoSelected bool   `json:"autoSelected"`
+}
+
+// autoSelection is what a build with the current configuration
+// would use
+type autoSelection struct {
+	version  Version
+	libDir   string
+	srcDir   string
+	cacheDir string
+	err      error
+}
+
+func resolveAutoSelection(conf *Conf) autoSelection {
+	var ans autoSelection
+	ans.cacheDir, ans.err = conf.CacheRoot()
+	if ans.err != nil {
+		return ans
+	}
+	detected, err := AutodetectManateeVersion(conf.ManateeLib, KnownVersions)
+	if conf.ManateeVersion != "" {
+		ans.version, ans.err = resolveManateeVersion(conf.ManateeVersion, detected, conf.ManateeLib, conf.AllowUnknownVersion)
+
+	} else {
+		ans.version, ans.err = detected, err
+	}
+	if ans.err != nil || ans.version.IsZero() {
+		return ans
+	}
+	ans.libDir = conf.ManateeLib
+	if ans.libDir == "" {
+		ans.libDir, _ = findManatee(ans.version)
+	}
+	ans.srcDir = conf.ManateeSrc
+	if ans.srcDir == "" {
+		ans.srcDir = manateeSrcDir(ans.cacheDir, ans.version)
+	}
+	return ans
+}
+
+func findCachedManatee(cacheDir string) (sources []inventoryItem, archives []inventoryItem) {
+	sources = make([]inventoryItem, 0, 5)
+	archives = make([]inventoryItem, 0, 5)
+	entries, err := listCacheEntries(cacheDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.Kind == cacheKindPartial {
+			continue
+		}
+		item := inventoryItem{
+			Kind:    entry.Kind,
+			Version: entry.Version.Full(),
+			Path:    entry.Path,
+			Note:    "last used " + entry.LastUsed.Format(time.DateTime),
+		}
+		if entry.Kind == cacheKindSources {
+			sources = append(sources, item)
+
+		} else {
+			archives = append(archives, item)
+		}
+	}
+	return
+}
+
+// manateeInventory lists all the Manatee versions known to manabuild
+func manateeInventory(conf *Conf) ([]inventoryItem, autoSelection) {
+	sel := resolveAutoSelection(conf)
+	ans := make([]inventoryItem, 0, len(KnownVersions)+10)
+
+	for _, kv := range KnownVersions {
+		item := inventoryItem{Kind: inventoryKindSupported, Version: kv}
+		if v, err := ParseManateeVersion(kv); err == nil && sel.err == nil {
+			item.AutoSelected = v.Semver() == sel.version.Semver()
+		}
+		ans = append(ans, item)
+	}
+
+	libs := make([]inventoryItem, 0, 10)
+	addLib := func(libPath, source string) {
+		item := inventoryItem{Kind: inventoryKindLibrary, Path: libPath}
+		notes := []string{source}
+		if v, err := libManateeVersion(libPath); err != nil {
+			item.Version = "?"
+			notes = append(notes, err.Error())
+
+		} else {
+			item.Version = v.Full()
+			item.AutoSelected = sel.libDir != "" && v.Eq(sel.version) &&
+				filepath.Clean(sel.libDir) == filepath.Dir(libPath)
+		}
+		if !IsOnDefaultLoaderPath(filepath.Dir(libPath)) {
+			notes = append(notes, "not on default loader path")
+		}
+		item.Note = strings.Join(notes, ", ")
+		libs = append(libs, item)
+	}
+	listed := make(map[string]bool)
+	for _, inst := range FindManateeInstallations() {
+		listed[inst.LibPath] = true
+		addLib(inst.LibPath, inst.Source)
+	}
+	if conf.ManateeLib != "" {
+		libPath := filepath.Clean(filepath.Join(conf.ManateeLib, "libmanatee.so"))
+		if !listed[libPath] {
+			addLib(libPath, "configured")
+		}
+	}
+	ans = append(ans, libs...)
+
+	sources, archives := findCachedManatee(sel.cacheDir)
+	if conf.ManateeSrc != "" {
+		item := inventoryItem{Kind: cacheKindSources, Path: conf.ManateeSrc, Note: "configured"}
+		if v, err := srcManateeVersion(conf.ManateeSrc); err != nil {
+			item.Version = "?"
+			item.Note += ", " + err.Error()
+
+		} else {
+			item.Version = v.Full()
+		}
+		sources = append(sources, item)
+	}
+	for i := range sources {
+		sources[i].AutoSelected = sel.srcDir != "" && filepath.Clean(sources[i].Path) == filepath.Clean(sel.srcDir)
+	}
+	for i := range archives {
+		archives[i].AutoSelected = conf.ManateeSrc == "" && sel.err == nil &&
+			archives[i].Path == manateeArchivePath(sel.cacheDir, sel.version)
+	}
+	ans = append(ans, sources...)
+	ans = append(ans, archives...)
+
+	// builds against unknown versions (see -allow-unknown-version)
+	var outcomes []unknownVersionOutcome
+	if cacheDir, err := conf.CacheRoot(); err == nil {
+		outcomes, _ = loadUnknownVersionOutcomes(cacheDir)
+	}
+	for _, outcome := range outcomes {
+		item := inventoryItem{Kind: inventoryKindTried, Version: outcome.Version, Path: outcome.Project}
+		if outcome.Success {
+			item.Note = fmt.Sprintf("succeeded %s", outcome.Time.Format(time.DateTime))
+
+		} else {
+			item.Note = fmt.Sprintf(
+				"failed (%s) %s", outcome.FailedOperation, outcome.Time.Format(time.DateTime))
+		}
+		item.Note += ", recipe " + outcome.Recipe
+		ans = append(ans, item)
+	}
+	return ans, sel
+}
+
+func showInventory(items []inventoryItem, sel autoSelection) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tKIND\tVERSION\tPATH\tNOTE")
+	for _, item := range items {
+		mark := ""
+		if item.AutoSelected {
+			mark = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", mark, item.Kind, item.Version, item.Path, item.Note)
+	}
+	tw.Flush()
+	if sel.err != nil {
+		fmt.Fprintf(os.Stderr, "\nauto-selection failed: %s\n", sel.err)
+
+	} else if sel.version.IsZero() {
+		fmt.Fprintln(os.Stderr, "\nno version would be auto-selected")
+
+	} else {
+		fmt.Fprintf(os.Stderr, "\n* auto-selected for the current configuration (%s)\n", sel.version.Full())
+	}
+}
+
+func runVersionsCommand(args []string, loadConf func() (*Conf, error)) error {
+	fset := flag.NewFlagSet("versions", flag.ContinueOnError)
+	asJSON := fset.Bool("json", false, "Print the list as JSON")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	conf, err := loadConf()
+	if err != nil {
+		return err
+	}
+	items, sel := manateeInventory(conf)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(items)
+	}
+	showInventory(items, sel)
+	return nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2023 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2023 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of CNC-MASM.
+//
+//  CNC-MASM is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  CNC-MASM is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with CNC-MASM.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindCachedManatee(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"manatee-open-2.225.8", "manatee-open-2.225.8-cnc", "manatee-open-foo", "other"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{"manatee-open-2.208.0.tar.gz", "manatee-open-2.208.0.zip"} {
+		if err := os.WriteFile(filepath.Join(dir, f), []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sources, archives := findCachedManatee(dir)
+	if len(sources) != 2 || sources[0].Version != "2.225.8" || sources[1].Version != "2.225.8-cnc" {
+		t.Errorf("unexpected source trees %v", sources)
+	}
+	if len(archives) != 1 || archives[0].Version != "2.208.0" {
+		t.Errorf("unexpected archives %v", archives)
+	}
+}